@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// defaultConfigFileは-configが指定されていない場合にカレントディレクトリから探す設定ファイルです。
+const defaultConfigFile = ".github-app-token.yaml"
+
+// readConfigは"key: value"形式のフラットなyamlを読み込みます。
+// キーはフラグ名で、空行と#から始まるコメントは無視します。
+func readConfig(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	config := map[string]string{}
+
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("malformed line %d in config file %s: expected key: value", number, path)
+		}
+
+		key := strings.TrimSpace(kv[0])
+		if _, ok := config[key]; ok {
+			return nil, fmt.Errorf("duplicate key %q in config file %s", key, path)
+		}
+		config[key] = unquote(strings.TrimSpace(kv[1]))
+	}
+
+	return config, scanner.Err()
+}
+
+// unquoteは値を囲む引用符を取り除きます。
+func unquote(value string) string {
+	if len(value) >= 2 {
+		first, last := value[0], value[len(value)-1]
+		if (first == '"' && last == '"') || (first == '\'' && last == '\'') {
+			return value[1 : len(value)-1]
+		}
+	}
+	return value
+}
+
+// getConfigPathは読み込む設定ファイルのパスを返します。
+// pathが空でカレントディレクトリにも設定ファイルが無い場合は空文字を返します。
+func getConfigPath(path string) string {
+	if path != "" {
+		return path
+	}
+
+	if _, err := os.Stat(defaultConfigFile); err == nil {
+		return defaultConfigFile
+	}
+	return ""
+}
+
+// applyConfigは設定ファイルの値をコマンドラインで指定されていないフラグに設定します。
+// 設定ファイルは環境変数より優先されるため、環境変数の値を使用する前に呼び出します。
+func applyConfig(path string) error {
+	config, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+
+	specified := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		specified[f.Name] = true
+	})
+
+	for key, value := range config {
+		if key == "config" || flag.Lookup(key) == nil {
+			return fmt.Errorf("unknown key %q in config file %s", key, path)
+		}
+
+		// コマンドラインで指定されたフラグは設定ファイルより優先する
+		if specified[key] {
+			continue
+		}
+
+		err := flag.Set(key, value)
+		if err != nil {
+			return fmt.Errorf("invalid value %q for key %q in config file %s: %w", value, key, path, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// readEnvFileは"KEY=VALUE"形式の.envファイルを読み込みます。
+// 空行と#から始まるコメントは無視し、行頭のexportも取り除きます。
+// 値は引用符で囲むことができ、囲んでいない値では空白に続く#以降をコメントとして扱います。
+func readEnvFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	env := map[string]string{}
+
+	scanner := bufio.NewScanner(file)
+	// 秘密鍵を1行で書いた値はbufio.Scannerの既定の上限を超えることがある
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		kv := strings.SplitN(line, "=", 2)
+		key := ""
+		if len(kv) == 2 {
+			key = strings.TrimSpace(kv[0])
+		}
+		if !exportVarPattern.MatchString(key) {
+			return nil, fmt.Errorf("malformed line %d in env file %s: expected KEY=VALUE", number, path)
+		}
+
+		value, err := parseEnvValue(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, fmt.Errorf("malformed line %d in env file %s: %w", number, path, err)
+		}
+		env[key] = value
+	}
+
+	return env, scanner.Err()
+}
+
+// parseEnvValueは.envファイルの値から引用符や行末のコメントを取り除きます。
+func parseEnvValue(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	if quote := value[0]; quote == '"' || quote == '\'' {
+		end := strings.IndexByte(value[1:], quote)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated quoted value")
+		}
+
+		rest := strings.TrimSpace(value[end+2:])
+		if rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected characters after quoted value")
+		}
+		return value[1 : end+1], nil
+	}
+
+	if index := strings.Index(value, " #"); index >= 0 {
+		value = strings.TrimSpace(value[:index])
+	}
+	return value, nil
+}
+
+// loadEnvFileは.envファイルの値を環境変数に設定します。
+// 既に設定されている環境変数は上書きしません。
+func loadEnvFile(path string) error {
+	env, err := readEnvFile(path)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range env {
+		if _, ok := os.LookupEnv(key); ok {
+			continue
+		}
+
+		err := os.Setenv(key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
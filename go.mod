@@ -2,4 +2,17 @@ module github.com/zerosspec-dev/github-app-token
 
 go 1.18
 
-require github.com/golang-jwt/jwt/v5 v5.0.0 // direct
+require (
+	github.com/golang-jwt/jwt/v5 v5.0.0 // direct
+	github.com/miekg/pkcs11 v1.1.1
+	github.com/youmark/pkcs8 v0.0.0-20240726163527-a2c0da244d78
+	golang.org/x/oauth2 v0.10.0
+)
+
+require (
+	github.com/golang/protobuf v1.5.3 // indirect
+	golang.org/x/crypto v0.22.0 // indirect
+	golang.org/x/net v0.21.0 // indirect
+	google.golang.org/appengine v1.6.7 // indirect
+	google.golang.org/protobuf v1.31.0 // indirect
+)
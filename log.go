@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zerosspec-dev/github-app-token/token"
+)
+
+// logFormatsは-log-formatに指定できる形式です。
+var logFormats = map[string]bool{
+	"text": true,
+	"json": true,
+}
+
+// textPrefixesはtext形式で出力する際のレベルごとの接頭辞です。
+var textPrefixes = map[string]string{
+	"info":  "",
+	"warn":  "warning: ",
+	"debug": "debug: ",
+	"error": "error occurred: ",
+}
+
+// cliLoggerはログを標準エラー出力に書き出します。
+// formatが"json"の場合は1行に1つのJSONオブジェクトとして書き出します。
+// verboseがtrueの場合は通信の詳細も書き出します。
+// maskSecretsがtrueの場合は取得したトークンやJWTをログから伏せます。
+// quietがtrueの場合はエラー以外を書き出しません。verboseより優先します。
+type cliLogger struct {
+	verbose     bool
+	quiet       bool
+	format      string
+	maskSecrets bool
+	out         io.Writer
+	secrets     []string
+}
+
+// loggerはコマンド全体で使用するロガーです。
+var logger = &cliLogger{format: "text", maskSecrets: true, out: os.Stderr}
+
+// addSecretはログから伏せる値を登録します。
+func (logger *cliLogger) addSecret(secret string) {
+	if secret != "" {
+		logger.secrets = append(logger.secrets, secret)
+	}
+}
+
+// sanitizeはtextに含まれるトークンやJWTを伏せます。
+func (logger *cliLogger) sanitize(text string) string {
+	if !logger.maskSecrets {
+		return text
+	}
+
+	for _, secret := range logger.secrets {
+		text = strings.ReplaceAll(text, secret, "***")
+	}
+	// addSecretで登録されていない値も誤って出力しないよう、トークンとJWTの形式の部分も伏せる
+	return token.RedactSecrets(text)
+}
+
+func (logger *cliLogger) Info(msg string, fields ...token.Field) {
+	if !logger.quiet {
+		logger.log("info", msg, fields)
+	}
+}
+
+func (logger *cliLogger) Warn(msg string, fields ...token.Field) {
+	if !logger.quiet {
+		logger.log("warn", msg, fields)
+	}
+}
+
+func (logger *cliLogger) Debug(msg string, fields ...token.Field) {
+	if logger.verbose && !logger.quiet {
+		logger.log("debug", msg, fields)
+	}
+}
+
+func (logger *cliLogger) Error(msg string, fields ...token.Field) {
+	logger.log("error", msg, fields)
+}
+
+// logは1件のログを指定された形式で書き出します。
+func (logger *cliLogger) log(level string, msg string, fields []token.Field) {
+	// エラーメッセージなどに紛れ込んだトークンを出力しないよう、書き出す直前に伏せる
+	msg = logger.sanitize(msg)
+	sanitized := make([]token.Field, 0, len(fields))
+	for _, field := range fields {
+		if value, ok := field.Value.(string); ok {
+			field.Value = logger.sanitize(value)
+		}
+		sanitized = append(sanitized, field)
+	}
+	fields = sanitized
+
+	var line string
+	if logger.format == "json" {
+		line = formatJsonLog(level, msg, fields)
+	} else {
+		line = formatTextLog(level, msg, fields)
+	}
+
+	fmt.Fprintln(logger.out, line)
+}
+
+// formatTextLogはログを"接頭辞 メッセージ key=value ..."の形式にします。
+func formatTextLog(level string, msg string, fields []token.Field) string {
+	var builder strings.Builder
+	builder.WriteString(textPrefixes[level])
+	builder.WriteString(msg)
+
+	for _, field := range fields {
+		// JSONの値はJSON形式のログと同じくそのまま書き出す
+		if raw, ok := field.Value.(json.RawMessage); ok {
+			fmt.Fprintf(&builder, " %s=%s", field.Key, raw)
+			continue
+		}
+
+		value := fmt.Sprint(field.Value)
+		if strings.ContainsAny(value, " \t\n\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(&builder, " %s=%s", field.Key, value)
+	}
+
+	return builder.String()
+}
+
+// formatJsonLogはログをJSONオブジェクトにします。
+// level、msg、timeの後にfieldsを渡された順に並べます。
+func formatJsonLog(level string, msg string, fields []token.Field) string {
+	fields = append([]token.Field{
+		{Key: "level", Value: level},
+		{Key: "msg", Value: msg},
+		{Key: "time", Value: time.Now().UTC().Format(time.RFC3339)},
+	}, fields...)
+
+	var builder strings.Builder
+	builder.WriteString("{")
+	for i, field := range fields {
+		if i > 0 {
+			builder.WriteString(",")
+		}
+
+		key, _ := json.Marshal(field.Key)
+		value, err := json.Marshal(field.Value)
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(field.Value))
+		}
+		builder.Write(key)
+		builder.WriteString(":")
+		builder.Write(value)
+	}
+	builder.WriteString("}")
+
+	return builder.String()
+}
+
+// logRateLimitはAPIを呼び出した後のレート制限の状態を書き出します。
+func logRateLimit(method string, url string, rateLimit token.RateLimit) {
+	logger.Info("rate limit",
+		token.Field{Key: "method", Value: method},
+		token.Field{Key: "url", Value: url},
+		token.Field{Key: "resource", Value: rateLimit.Resource},
+		token.Field{Key: "remaining", Value: rateLimit.Remaining},
+		token.Field{Key: "limit", Value: rateLimit.Limit},
+		token.Field{Key: "reset", Value: rateLimit.Reset.UTC().Format(time.RFC3339)},
+	)
+}
+
+// exitCodesはエラーの分類ごとの終了コードです。分類されていないエラーは1で終了します。
+var exitCodes = map[token.ErrorKind]int{
+	token.KindInvalidArgument: 2,
+	token.KindAuth:            3,
+	token.KindNetwork:         4,
+	token.KindRateLimit:       5,
+}
+
+// getExitCodeはerrに対応する終了コードを返します。
+func getExitCode(err error) int {
+	if code, ok := exitCodes[token.KindOf(err)]; ok {
+		return code
+	}
+	return 1
+}
+
+// usageErrorは引数の誤りを示すエラーを返します。
+func usageError(format string, a ...interface{}) error {
+	return &token.Error{Kind: token.KindInvalidArgument, Err: fmt.Errorf(format, a...)}
+}
+
+// checkErrorは必須の引数が指定されていない場合に終了します。
+func checkError(value string, name string) {
+	if value == "" {
+		exitWithError(usageError("%s is not set", name))
+	}
+}
+
+// exitWithErrorはエラーをログに書き出し、エラーの分類に対応する終了コードで終了します。
+// GitHub APIのエラーの場合はステータスコードやリクエストIDなども書き出します。
+func exitWithError(err error) {
+	var fields []token.Field
+
+	var responseErr *token.ResponseError
+	if errors.As(err, &responseErr) {
+		fields = append(fields,
+			token.Field{Key: "method", Value: responseErr.Method},
+			token.Field{Key: "url", Value: responseErr.Url},
+			token.Field{Key: "status", Value: responseErr.StatusCode},
+		)
+		if responseErr.RequestId != "" {
+			fields = append(fields, token.Field{Key: "request_id", Value: responseErr.RequestId})
+		}
+	}
+
+	logger.Error(err.Error(), fields...)
+	os.Exit(getExitCode(err))
+}
@@ -1,196 +1,1368 @@
+// github-app-tokenはGitHub Appsのインストールアクセストークンを取得して出力します。
+//
+// インストールの参照先はフラグの組み合わせで決まります。
+//
+//   - -org と -repo を指定: /repos/<org>/<repo>/installation (リポジトリへのインストール)
+//   - -org のみ指定: /orgs/<org>/installation (orgへのインストール)。
+//     見つからなければAppのインストールの一覧から -org のオーナーのインストールが1つの場合にそれを使用
+//   - -repo-lookup-id を指定: /repositories/<id>/installation (名前を変更しても変わらないIDで参照)
+//   - -account を指定: /orgs/<account>/installation、見つからなければ /users/<account>/installation
+//   - -fallback-org を指定: -org で見つからなければ -fallback-org のオーナーで同じく参照 (移管中のリポジトリ向け)
+//   - -installation-id を指定: 参照を省略し、-org と -repo は不要
+//
+// 最初の引数にサブコマンドを指定すると別の操作を行います。
+//
+//   - revoke: -token または標準入力で渡したトークンを失効させます
+//   - list-installations: Appのインストールの一覧を出力します。-org を指定した場合はorgにインストールされているAppの一覧を出力します
+//   - list-repos: トークンを取得し、そのトークンでアクセスできるリポジトリの一覧を出力します。-token を指定した場合はそのトークンを使用します
+//   - check: 秘密鍵とAppの設定でJWTの認証ができることを確認し、Appのslugとインストール数を出力します
+//   - all-installations: Appのすべてのインストールのトークンを -concurrency の数まで並行して取得し、jsonの配列で出力します。一部が失敗した場合は取得できたものを出力してから失敗として終了します
+//   - ttl: -expires-at の時刻、または新たに取得したトークンの有効期限までの残り秒数を出力します
+//   - diff-permissions: -permissions の権限でトークンを取得し、要求と実際に付与された権限の違いを表で出力します。付与されなかった権限がある場合は終了コード3で終了します
+//   - user-token: デバイスフローでユーザーアクセストークンを取得して出力します。-client-id が必要で、秘密鍵は使用しません
+//
+// 取得済みのトークンを渡す -token に - を指定すると標準入力から読み込みます。
+// コマンドラインの引数はpsなどで他のユーザーからも見えるため、こちらを推奨します。
+//
+//	echo "$TOKEN" | github-app-token revoke -token -
+//
+// フラグの既定値は -config で指定した設定ファイル、または
+// カレントディレクトリの .github-app-token.yaml に書くことができます。
+// 設定ファイルはフラグ名をキーとする"key: value"形式のフラットなyamlで、未知のキーはエラーになります。
+//
+//	app: 123
+//	org: example
+//	pem: /path/to/key.pem
+//
+// 以下のフラグは省略時に環境変数の値を使用します。
+// コマンドラインのフラグ、設定ファイル、環境変数の順に優先します。
+//
+//   - -app: GITHUB_APP_ID
+//   - -client-id: GITHUB_APP_CLIENT_ID
+//   - -api-url: GITHUB_API_URL
+//   - -web-url: GITHUB_SERVER_URL
+//   - -org と -repo: GITHUB_REPOSITORY (owner/repo の形式。-installation-id を指定した場合は使用しません)
+//   - -pem: GITHUB_APP_PRIVATE_KEY (-pem-env で変更できます)
+//   - -pem-base64: GITHUB_APP_PRIVATE_KEY_BASE64
+//   - -passphrase: GITHUB_APP_KEY_PASSPHRASE
+//   - -pkcs11-pin: GITHUB_APP_PKCS11_PIN
+//
+// -env-file で指定した.envファイルの"KEY=VALUE"の行は、これらの環境変数を読む前に設定されます。
+// 既に設定されている環境変数は上書きしません。
+//
+// 終了コードは失敗の原因によって異なります。
+//
+//   - 0: 成功
+//   - 1: その他のエラー
+//   - 2: 引数の誤り
+//   - 3: 秘密鍵の読み込みやJWTの署名、GitHubでの認証の失敗
+//   - 4: 通信の失敗やGitHub APIのエラー
+//   - 5: GitHub APIのレート制限
+//
+// -least-privilege を指定するとcontents:readのみを要求し、-repo を指定した場合はそのリポジトリに限ります。
+// contents:readとmetadata:read以外の権限が付与された場合はトークンを失効させて失敗します。-watch では書き換えのたびに確認します。
+// インストールごとの権限を確認できない all-installations とは同時に指定できません。
+// GitHub ActionsのOIDCで得た認証情報と引き換えに、範囲を絞ったトークンを発行する場合などに使用します。
+//
+// -output jwt を指定するとインストールアクセストークンではなく、
+// App自身のAPIを呼び出すためのJWTを出力します。JWTの有効期間は -jwt-lifetime と -jwt-clock-skew に従います。
+//
+// -output json-full を指定するとトークンの取得に使用したAppのJWTとその有効期限も合わせてjsonで出力します。
+//
+// -output export を指定するとシェルでevalできる形式で出力します。
+//
+//	eval "$(github-app-token -app 123 -pem key.pem -org org -output export)"
+//
+// -output actions を指定するとGitHub Actionsのログでトークンをマスクし、
+// $GITHUB_OUTPUT に token として書き出します。後続のステップでは steps.<id>.outputs.token で参照できます。
+//
+// -output netrc を指定すると.netrcのmachineエントリを出力します。ホスト名は -api-url から決まります。
+// -output-file を指定した場合は既存の.netrcの同じホストのエントリを置き換えます。
+//
+//	github-app-token -app 123 -pem key.pem -org org -output netrc -output-file ~/.netrc
+//
+// -watch を指定すると終了せずに動作し続け、トークンの有効期限の -refresh-margin 前に
+// 新しいトークンを取得して -output-file を書き換えます。SIGINTまたはSIGTERMで終了します。
+//
+//	github-app-token -app 123 -pem key.pem -org org -output-file /run/secrets/token -watch
+//
+// -output git-credential を指定するとgitのcredential helperとして動作します。
+// トークンを渡すのは -api-url に対応するホストへのhttpsの要求に限り、他のホストには何も出力しません。
+//
+//	git config credential.helper '!github-app-token -app 123 -pem key.pem -org org -output git-credential'
 package main
 
 import (
-	"crypto/rsa"
-	"crypto/x509"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
-	"encoding/pem"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
 
-	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/zerosspec-dev/github-app-token/token"
+	"github.com/zerosspec-dev/github-app-token/token/pkcs11"
 )
 
-// sendはリクエストの結果をtargetにマップします。
-func send(authorization *string, method string, url *string, target interface{}) error {
+// ビルド時に-ldflagsの-Xで設定されるバージョン情報です。
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
+
+// optionsはtoken.AccessTokenに含まれないコマンドライン引数です。
+type options struct {
+	output              string
+	pemEnv              string
+	pemInline           string
+	permissions         string
+	repositories        string
+	repositoryIds       string
+	printInstallationId bool
+	token               string
+	expiresAt           string
+	outputFile          string
+	exportVar           string
+	noNewline           bool
+	outputFileNewline   bool
+	expiryFormat        string
+	minTtl              time.Duration
+	headers             headerFlags
+	watch               bool
+	pkcs11              pkcs11.Config
+	dryRun              bool
+	printRateLimit      bool
+	printJwtClaims      bool
+	leastPrivilege      bool
+	logApp              bool
+	version             bool
+	config              string
+	envFile             string
+
+	// appJwtはトークンの取得に使用したJWTで、-output json-fullの場合に出力します。
+	appJwt          string
+	appJwtExpiresAt time.Time
+
+	// credentialはgitのcredential helperとして標準入力から受け取った属性です。
+	credential map[string]string
+}
+
+// outputFormatsは-outputに指定できる形式です。
+var outputFormats = map[string]bool{
+	"text":           true,
+	"json":           true,
+	"git-credential": true,
+	"jwt":            true,
+	"export":         true,
+	"actions":        true,
+	"netrc":          true,
+	"json-full":      true,
+}
+
+// headerFlagsは繰り返し指定できる-headerの値です。
+type headerFlags []string
+
+func (flags *headerFlags) String() string {
+	return strings.Join(*flags, ", ")
+}
+
+func (flags *headerFlags) Set(value string) error {
+	*flags = append(*flags, value)
+	return nil
+}
+
+// parseHeadersは"Name: Value"形式の-headerの値をヘッダに変換します。
+// AuthorizationやAcceptなど上書きできないヘッダは警告して無視し、形式が誤っている場合は終了します。
+func parseHeaders(values []string) http.Header {
+	if len(values) == 0 {
+		return nil
+	}
+
+	header := http.Header{}
+	for _, value := range values {
+		parts := strings.SplitN(value, ":", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" || strings.ContainsAny(name, " \t") {
+			exitWithError(usageError("malformed header %q: expected Name: Value", value))
+		}
+
+		if token.IsReservedHeader(name) {
+			logger.Warn(fmt.Sprintf("header %s cannot be overridden by -header, ignoring it", http.CanonicalHeaderKey(name)))
+			continue
+		}
+		header.Add(name, strings.TrimSpace(parts[1]))
+	}
+
+	return header
+}
+
+// expiryFormatsは-expiry-formatに指定できる有効期限の形式です。
+var expiryFormats = map[string]bool{
+	"rfc3339":  true,
+	"unix":     true,
+	"relative": true,
+}
+
+// formatExpiryは有効期限を-expiry-formatの形式の文字列にします。
+// relativeは現在からの残り時間で、有効期限を過ぎている場合は0sです。
+func formatExpiry(opts *options, expiresAt time.Time) string {
+	switch opts.expiryFormat {
+	case "unix":
+		return strconv.FormatInt(expiresAt.Unix(), 10)
+	case "relative":
+		remaining := time.Until(expiresAt).Truncate(time.Second)
+		if remaining < 0 {
+			remaining = 0
+		}
+		return remaining.String()
+	default:
+		return expiresAt.UTC().Format(time.RFC3339)
+	}
+}
+
+// exportVarPatternは-export-varに指定できるシェルの変数名です。
+var exportVarPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// shellQuoteは値をシェルで解釈されないようシングルクォートで囲みます。
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// splitRepositoryは-repoがGITHUB_REPOSITORYと同じowner/repoの形式の場合に、-orgと-repoに分けます。
+// -orgも指定されていてownerと異なる場合はエラーを返します。
+func splitRepository(args *token.AccessToken) error {
+	owner, name, ok := strings.Cut(args.RepositoryName, "/")
+	if !ok {
+		return nil
+	}
+
+	if owner == "" || name == "" || strings.Contains(name, "/") {
+		return usageError("malformed repo %q: expected repo or owner/repo", args.RepositoryName)
+	}
+	if args.OrganizationName != "" && args.OrganizationName != owner {
+		return usageError("org %q does not match the owner of repo %q", args.OrganizationName, args.RepositoryName)
+	}
+
+	args.OrganizationName, args.RepositoryName = owner, name
+	return nil
+}
+
+// readCredentialはgitのcredential helperのプロトコルに従って標準入力から属性を読み込みます。
+// 属性は空行または入力の終わりまで"key=value"の形式で渡されます。
+func readCredential() (map[string]string, error) {
+	credential := map[string]string{}
 
-	// 送信
-	request, err := http.NewRequest(method, *url, nil)
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("malformed credential attribute: %q", line)
+		}
+		credential[kv[0]] = kv[1]
+	}
+
+	return credential, scanner.Err()
+}
+
+// getCredentialHostはAPIのベースURLからgitで使用するホスト名を返します。
+func getCredentialHost(apiUrl string) string {
+	parsed, err := url.Parse(apiUrl)
+	if err != nil || parsed.Host == "" {
+		return "github.com"
+	}
+
+	// api.github.comの場合は対応するgithub.comにする
+	return strings.TrimPrefix(parsed.Host, "api.")
+}
+
+// getApiCredentialHostはトークンを使用できるGitHubのホスト名を返します。
+func getApiCredentialHost(args *token.AccessToken) string {
+	apiUrl := args.ApiUrl
+	if apiUrl == "" {
+		apiUrl = token.DefaultApiUrl
+	}
+	return getCredentialHost(apiUrl)
+}
+
+// matchesCredentialHostはgitから要求された認証情報がトークンを使用できるGitHubのものかどうかを返します。
+// 他のリモートにトークンを渡さないよう、httpsで-api-urlに対応するホストの場合に限ります。
+func matchesCredentialHost(args *token.AccessToken, credential map[string]string) bool {
+	return credential["protocol"] == "https" && strings.EqualFold(credential["host"], getApiCredentialHost(args))
+}
+
+// printGitCredentialはgitのcredential helperの形式でアクセストークンを書き出します。
+func printGitCredential(args *token.AccessToken, opts *options, result *token.Token) {
+	protocol := opts.credential["protocol"]
+	if protocol == "" {
+		protocol = "https"
+	}
+
+	host := opts.credential["host"]
+	if host == "" {
+		host = getApiCredentialHost(args)
+	}
+
+	fmt.Fprintf(os.Stdout, "protocol=%s\n", protocol)
+	fmt.Fprintf(os.Stdout, "host=%s\n", host)
+	fmt.Fprintf(os.Stdout, "username=x-access-token\n")
+	fmt.Fprintf(os.Stdout, "password=%s\n", result.Token)
+}
+
+// writeFileAtomicはdataを一時ファイルに書き込んでからpathに移動します。
+// 途中で異常終了しても書きかけのファイルが残らないようにするためです。
+// ファイルは所有者のみ読み書きできる権限で作成し、親ディレクトリが無い場合は作成します。
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	temp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	err = temp.Chmod(0600)
+	if err == nil {
+		_, err = temp.Write(data)
+	}
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return err
 	}
 
-	request.Header = map[string][]string{
-		"Accept":               {"application/vnd.github+json"},
-		"X-GitHub-Api-Version": {"2022-11-28"},
-		"Authorization":        {fmt.Sprintf("Bearer %s", *authorization)},
+	return os.Rename(temp.Name(), path)
+}
+
+// printActionsはGitHub Actionsのワークフローコマンドでトークンをマスクし、ステップの出力にします。
+// $GITHUB_OUTPUTが設定されていない場合はマスクのみ行います。
+func printActions(result *token.Token) error {
+	fmt.Fprintf(os.Stdout, "::add-mask::%s\n", result.Token)
+
+	path := os.Getenv("GITHUB_OUTPUT")
+	if path == "" {
+		logger.Warn("GITHUB_OUTPUT is not set, the token is only masked and not set as a step output")
+		return nil
 	}
 
-	response, err := http.DefaultClient.Do(request)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
 	if err != nil {
 		return err
 	}
 
-	defer response.Body.Close()
+	_, err = fmt.Fprintf(file, "token=%s\n", result.Token)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writeTokenFileはアクセストークンのみを-output-fileに書き出します。
+func writeTokenFile(opts *options, result *token.Token) error {
+	data := result.Token
+	if opts.outputFileNewline {
+		data += "\n"
+	}
 
-	if response.StatusCode/100 != 2 {
-		return fmt.Errorf("request failed: %s", response.Status)
+	return writeFileAtomic(opts.outputFile, []byte(data))
+}
+
+// printJsonFullはAppのJWTとインストールアクセストークンを1つのjsonにして書き出します。
+// JWTはトークンの取得に使用したものを再利用し、キャッシュからトークンを取得した場合は新たに署名します。
+func printJsonFull(args *token.AccessToken, opts *options, result *token.Token) error {
+	if opts.appJwt == "" {
+		jwt, jwtExpiresAt, err := args.SignJwtWithExpiry()
+		if err != nil {
+			return err
+		}
+		logger.addSecret(jwt)
+		opts.appJwt, opts.appJwtExpiresAt = jwt, jwtExpiresAt
 	}
 
-	body, err := ioutil.ReadAll(response.Body)
+	expiresAt, err := result.ExpiresAtTime()
 	if err != nil {
 		return err
 	}
 
-	// jsonにマッピングする
-	err = json.Unmarshal(body, target)
+	out, err := json.Marshal(struct {
+		Jwt            string           `json:"jwt"`
+		JwtExpiresAt   string           `json:"jwt_expires_at"`
+		JwtTtlSeconds  int64            `json:"jwt_ttl_seconds"`
+		Token          string           `json:"token"`
+		ExpiresAt      string           `json:"expires_at"`
+		InstallationId int              `json:"installation_id"`
+		RateLimit      *token.RateLimit `json:"rate_limit,omitempty"`
+	}{
+		Jwt:            opts.appJwt,
+		JwtExpiresAt:   opts.appJwtExpiresAt.UTC().Format(time.RFC3339),
+		JwtTtlSeconds:  int64(time.Until(opts.appJwtExpiresAt).Seconds()),
+		Token:          result.Token,
+		ExpiresAt:      formatExpiry(opts, expiresAt),
+		InstallationId: result.InstallationId,
+		RateLimit:      result.RateLimit,
+	})
 	if err != nil {
 		return err
 	}
 
+	fmt.Fprintf(os.Stdout, "%s\n", out)
 	return nil
 }
 
-type InstallationApiResponse struct {
-	Id              int     `json:"id"`
-	AccessTokensUrl *string `json:"access_tokens_url"`
+// printTokenは指定された形式でアクセストークンを標準出力に書き出します。
+func printToken(args *token.AccessToken, opts *options, result *token.Token) error {
+	switch opts.output {
+	case "json":
+		expiresAt, err := result.ExpiresAtTime()
+		if err != nil {
+			return err
+		}
+
+		// 呼び出し元のトークンは変更しないよう、コピーの有効期限を書き換える
+		formatted := *result
+		formatted.ExpiresAt = formatExpiry(opts, expiresAt)
+
+		out, err := json.Marshal(formatted)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "%s\n", out)
+	case "json-full":
+		return printJsonFull(args, opts, result)
+	case "git-credential":
+		printGitCredential(args, opts, result)
+	case "actions":
+		return printActions(result)
+	case "netrc":
+		fmt.Fprint(os.Stdout, formatNetrcEntry(getNetrcHost(args.ApiUrl), result.Token))
+	case "export":
+		// eval "$(github-app-token ...)"で環境変数に設定できる形式にする
+		fmt.Fprintf(os.Stdout, "export %s=%s\n", opts.exportVar, shellQuote(result.Token))
+	default:
+		// TOKEN=$(github-app-token ...)のように改行を含めずに受け取りたい場合がある
+		if opts.noNewline {
+			fmt.Fprint(os.Stdout, result.Token)
+		} else {
+			fmt.Fprintf(os.Stdout, "%s\n", result.Token)
+		}
+	}
+
+	return nil
+}
+
+// runGetはアクセストークンを取得して出力します。
+func runGet(args *token.AccessToken, opts *options, operation string) {
+	// credential helperとしてはgetのみに応答し、storeやeraseは何もしない
+	if opts.output == "git-credential" {
+		if operation != "" && operation != "get" {
+			return
+		}
+
+		if operation == "get" {
+			if args.PemFilePath == "-" {
+				exitWithError(usageError("cannot read the private key from stdin in git credential helper mode"))
+			}
+
+			credential, err := readCredential()
+			if err != nil {
+				exitWithError(&token.Error{Kind: token.KindInvalidArgument, Err: err})
+			}
+
+			// 他のホストの場合は何も出力せずに終了し、gitに次のcredential helperを使わせる
+			if !matchesCredentialHost(args, credential) {
+				logger.Debug("ignoring credential request for another host", token.Field{Key: "protocol", Value: credential["protocol"]}, token.Field{Key: "host", Value: credential["host"]})
+				return
+			}
+			opts.credential = credential
+		}
+	}
+
+	closeSigner := setupSigner(args, opts)
+	defer closeSigner()
+
+	// -output jwtの場合はAppとして認証するためのJWTを出力し、GitHubには接続しない
+	// dry-runの場合も確認のために同じくJWTを出力する
+	if opts.output == "jwt" || opts.dryRun {
+		jwt, err := args.SignJwt()
+		if err != nil {
+			exitWithError(err)
+		}
+		logger.addSecret(jwt)
+		fmt.Fprintf(os.Stdout, "%s\n", jwt)
+		return
+	}
+
+	// インストールID、accountまたはリポジトリのIDが指定されている場合はorgとrepoからの参照は行わない
+	if args.InstallationId == 0 && args.AccountName == "" && args.RepositoryLookupId == 0 {
+		checkError(args.OrganizationName, "org")
+	}
+
+	setupRequest(args, opts)
+
+	// 最後に署名したJWTはトークンの取得に使用したもので、有効期限まで再利用できる
+	if opts.output == "json-full" {
+		printClaims := args.JwtFunc
+		args.JwtFunc = func(jwt string, expiresAt time.Time) {
+			logger.addSecret(jwt)
+			opts.appJwt, opts.appJwtExpiresAt = jwt, expiresAt
+			if printClaims != nil {
+				printClaims(jwt, expiresAt)
+			}
+		}
+	}
+
+	if opts.watch {
+		runWatch(args, opts)
+		return
+	}
+
+	result, err := args.Get()
+	if err != nil {
+		exitWithError(addHint(err))
+	}
+	logger.addSecret(result.Token)
+
+	if opts.printInstallationId {
+		logger.Info(fmt.Sprintf("installation id: %d", result.InstallationId))
+	}
+
+	err = checkMinTtl(opts, result)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	err = verifyLeastPrivilege(args, opts, result)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	// ファイルに書き出す場合はログに残らないよう標準出力には出力しない
+	if opts.outputFile != "" {
+		err = writeOutputFile(args, opts, result)
+	} else {
+		err = printToken(args, opts, result)
+	}
+	if err != nil {
+		exitWithError(err)
+	}
+}
+
+// writeOutputFileはアクセストークンを-output-fileに書き出します。
+// -output netrcの場合は.netrcのエントリとして書き出します。
+func writeOutputFile(args *token.AccessToken, opts *options, result *token.Token) error {
+	if opts.output == "netrc" {
+		return writeNetrcFile(args, opts, result)
+	}
+	return writeTokenFile(opts, result)
+}
+
+// printJwtClaimsは署名したJWTのクレームをJSONオブジェクトのclaimsとしてログに書き出します。
+// 明示的に指定された場合のみ呼び出されるため、-quietでも書き出します。
+// 署名は秘匿情報ではないが、JWTをそのまま再利用できないよう出力しません。
+func printJwtClaims(jwt string, expiresAt time.Time) {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return
+	}
+
+	claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		logger.Warn("failed to decode jwt claims", token.Field{Key: "error", Value: err.Error()})
+		return
+	}
+
+	// iatやexpが指数表記にならないよう数値はそのまま扱い、キーの順に並べ直す
+	values := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(claims))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		logger.Warn("failed to decode jwt claims", token.Field{Key: "error", Value: err.Error()})
+		return
+	}
+	object, err := json.Marshal(values)
+	if err != nil {
+		logger.Warn("failed to encode jwt claims", token.Field{Key: "error", Value: err.Error()})
+		return
+	}
+
+	logger.log("info", "jwt claims", []token.Field{{Key: "claims", Value: json.RawMessage(object)}})
+}
+
+// checkMinTtlはトークンの有効期限までの残り時間が-min-ttl以上あることを確認します。
+// 新たに取得したトークンは1時間有効なため、主にキャッシュした期限の近いトークンを再利用した場合に失敗します。
+func checkMinTtl(opts *options, result *token.Token) error {
+	if opts.minTtl <= 0 {
+		return nil
+	}
+
+	expiresAt, err := result.ExpiresAtTime()
+	if err != nil {
+		return err
+	}
+
+	ttl := time.Until(expiresAt).Truncate(time.Second)
+	if ttl < opts.minTtl {
+		return fmt.Errorf("token expires in %s, which is less than min-ttl %s: set refresh-margin to at least min-ttl to re-mint cached tokens earlier", ttl, opts.minTtl)
+	}
+	return nil
+}
+
+// setupSignerは秘密鍵またはPKCS#11の鍵を設定し、使い終わった後に呼び出す関数を返します。
+// 必須の引数が指定されていない場合は終了します。
+func setupSigner(args *token.AccessToken, opts *options) func() {
+	// 秘密鍵はファイルを環境変数より優先する
+	if env := os.Getenv(opts.pemEnv); env != "" {
+		if args.PemFilePath != "" {
+			logger.Warn(fmt.Sprintf("both pem and %s are set, using pem", opts.pemEnv))
+		} else {
+			args.PrivateKey = []byte(env)
+		}
+	}
+
+	// コマンドラインの引数はpsなどで他のユーザーからも見えるため、テスト用途に限る
+	if opts.pemInline != "" {
+		logger.Warn("private key given by pem-inline may appear in process listings, use it only for testing")
+		if args.PemFilePath == "" && len(args.PrivateKey) == 0 {
+			args.PrivateKey = []byte(opts.pemInline)
+		}
+	}
+
+	// 鍵の更新中は予備の鍵をカンマ区切りで続けて指定できる
+	if strings.Contains(args.PemFilePath, ",") {
+		paths := strings.Split(args.PemFilePath, ",")
+		args.PemFilePath, args.FallbackPemFilePaths = paths[0], paths[1:]
+	}
+
+	// JWTのissにはAppIDとClient IDのどちらも使用でき、-jwt-issで直接指定することもできる
+	if args.ClientId == "" && args.JwtIssuer == "" {
+		checkError(args.AppId, "app, client-id or jwt-iss")
+	}
+	if len(args.PrivateKey) == 0 && args.PrivateKeyBase64 == "" && opts.pkcs11.Module == "" {
+		checkError(args.PemFilePath, "pem")
+	}
+
+	// PKCS#11モジュールが指定された場合はHSM上の鍵で署名する
+	if opts.pkcs11.Module != "" {
+		checkError(opts.pkcs11.Label, "pkcs11-label")
+
+		signer, err := pkcs11.Open(opts.pkcs11)
+		if err != nil {
+			exitWithError(&token.Error{Kind: token.KindAuth, Err: err})
+		}
+		args.Signer = signer
+		return func() { signer.Close() }
+	}
+
+	return func() {}
+}
+
+// addHintはerrに対処の方法を付け加えます。
+func addHint(err error) error {
+	if errors.Is(err, token.ErrPassphraseRequired) {
+		return fmt.Errorf("%w: set -passphrase or GITHUB_APP_KEY_PASSPHRASE", err)
+	}
+	return err
 }
 
-type AccessTokenApiResponse struct {
-	Token string `json:"token"`
+// leastPrivilegePermissionsは-least-privilegeで要求する権限です。
+var leastPrivilegePermissions = "contents:read"
+
+// leastPrivilegeGrantedは-least-privilegeで付与されてよい権限です。
+// metadata:readは他の権限を要求すると常に付与されます。
+var leastPrivilegeGranted = map[string]string{
+	"contents": "read",
+	"metadata": "read",
 }
 
-type AccessToken struct {
-	AppId            *string
-	PemFilePath      *string
-	OrganizationName *string
-	RepositoryName   *string
+// setupLeastPrivilegeは-least-privilegeの場合にトークンの権限をcontents:readに限り、
+// -repoが指定されていればアクセスできるリポジトリもそのリポジトリに限ります。
+func setupLeastPrivilege(args *token.AccessToken, opts *options) {
+	if opts.permissions != "" {
+		exitWithError(usageError("least-privilege cannot be used with permissions"))
+	}
+	opts.permissions = leastPrivilegePermissions
+
+	if args.RepositoryName != "" && opts.repositories == "" && opts.repositoryIds == "" {
+		opts.repositories = args.RepositoryName
+	}
+
+	// contents:readが付与されなかった場合はトークンを使えないため失敗させる
+	args.StrictPermissions = true
 }
 
-func (args *AccessToken) CheckError(field *string, name string) {
-	if field == nil || *field == "" {
-		fmt.Fprintf(os.Stderr, "%s is not set\n", name)
-		os.Exit(1)
+// checkLeastPrivilegeは付与された権限がleastPrivilegeGrantedを超えていないことを確認します。
+func checkLeastPrivilege(result *token.Token) error {
+	excess := []string{}
+	for name, level := range result.Permissions {
+		if leastPrivilegeGranted[name] != level {
+			excess = append(excess, name+":"+level)
+		}
 	}
+	if len(excess) == 0 {
+		return nil
+	}
+
+	sort.Strings(excess)
+	return &token.Error{Kind: token.KindAuth, Err: fmt.Errorf("token is not least privilege, also granted %s", strings.Join(excess, ", "))}
 }
 
-// getRepoNameはgithub上のリポジトリ名を返します。
-func (args *AccessToken) getRepoName() string {
-	return fmt.Sprintf("%s/%s", *args.OrganizationName, *args.RepositoryName)
+// verifyLeastPrivilegeは-least-privilegeの場合に付与された権限を確認し、超えている場合はトークンを失効させてエラーを返します。
+// トークンを出力または書き出す前に必ず呼び出します。
+func verifyLeastPrivilege(args *token.AccessToken, opts *options, result *token.Token) error {
+	if !opts.leastPrivilege {
+		return nil
+	}
+
+	err := checkLeastPrivilege(result)
+	if err == nil {
+		return nil
+	}
+
+	// 出力しないトークンは失効させる。キャッシュしたトークンは他の呼び出しで再利用されるため残す
+	if args.CacheFile == "" {
+		if revokeErr := args.Revoke(result.Token); revokeErr != nil {
+			logger.Warn("failed to revoke the token", token.Field{Key: "error", Value: revokeErr.Error()})
+		}
+	}
+	return err
 }
 
-// readPrivateKeyはファイルから秘密鍵を読み出して返します。
-func (args *AccessToken) readPrivateKey() (*rsa.PrivateKey, error) {
-	secret, err := ioutil.ReadFile(*args.PemFilePath)
+// setupRequestはトークンに要求する権限とリポジトリを設定します。
+func setupRequest(args *token.AccessToken, opts *options) {
+	if opts.leastPrivilege {
+		setupLeastPrivilege(args, opts)
+	}
+
+	var err error
+	args.Permissions, err = token.ParsePermissions(opts.permissions)
+	if err != nil {
+		exitWithError(err)
+	}
+	args.Repositories, err = token.ParseRepositories(opts.repositories)
 	if err != nil {
-		return nil, err
+		exitWithError(err)
+	}
+	args.RepositoryIds, err = token.ParseRepositoryIds(opts.repositoryIds)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	if opts.repositories != "" && opts.repositoryIds != "" {
+		exitWithError(usageError("repositories and repository-ids cannot be used together"))
 	}
+}
+
+// runListInstallationsはインストールの一覧を出力します。
+// -orgを指定した場合はorgにインストールされているAppの一覧を出力します。
+func runListInstallations(args *token.AccessToken, opts *options) {
+	closeSigner := setupSigner(args, opts)
+	defer closeSigner()
+
+	setupRequest(args, opts)
 
-	block, _ := pem.Decode(secret)
-	privatekey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	installations, err := args.ListInstallations()
 	if err != nil {
-		return nil, err
+		exitWithError(addHint(err))
+	}
+
+	if opts.output == "json" {
+		out, err := json.Marshal(installations)
+		if err != nil {
+			exitWithError(err)
+		}
+		fmt.Fprintf(os.Stdout, "%s\n", out)
+		return
 	}
 
-	return privatekey, nil
+	for _, installation := range installations {
+		if installation.AppSlug != "" {
+			fmt.Fprintf(os.Stdout, "%d\t%s\t%s\n", installation.Id, installation.Account, installation.AppSlug)
+		} else {
+			fmt.Fprintf(os.Stdout, "%d\t%s\n", installation.Id, installation.Account)
+		}
+	}
 }
 
-// getAuthorizationはAuthorizationヘッダに設定する文字列を作成して返します。
-func (args *AccessToken) getAuthorization(privateKey *rsa.PrivateKey) (*string, error) {
-	token := jwt.NewWithClaims(
-		jwt.SigningMethodRS256,
-		jwt.MapClaims{
-			"iss": args.AppId,
-			"iat": jwt.NewNumericDate(time.Now().Add(-1 * time.Minute)),
-			"exp": jwt.NewNumericDate(time.Now().Add(+3 * time.Minute)),
-		},
-	)
+// runListReposはトークンを取得し、そのトークンでアクセスできるリポジトリの一覧を出力します。
+func runListRepos(args *token.AccessToken, opts *options) {
+	// 取得済みのトークンが指定された場合はそのトークンでアクセスできるリポジトリを出力する
+	if opts.token != "" {
+		value, err := readToken(opts, false)
+		if err != nil {
+			exitWithError(err)
+		}
+
+		repositories, err := args.ListRepositoriesForToken(context.Background(), value)
+		if err != nil {
+			exitWithError(err)
+		}
+		printRepositories(opts, repositories)
+		return
+	}
+
+	closeSigner := setupSigner(args, opts)
+	defer closeSigner()
 
-	ss, err := token.SignedString(privateKey)
+	if args.InstallationId == 0 && args.AccountName == "" && args.RepositoryLookupId == 0 {
+		checkError(args.OrganizationName, "org")
+	}
+
+	setupRequest(args, opts)
+
+	repositories, err := args.ListRepositories()
+	if err != nil {
+		exitWithError(addHint(err))
+	}
+	printRepositories(opts, repositories)
+}
+
+// printRepositoriesはリポジトリの一覧を指定された形式で標準出力に書き出します。
+func printRepositories(opts *options, repositories []token.Repository) {
+	if opts.output == "json" {
+		out, err := json.Marshal(repositories)
+		if err != nil {
+			exitWithError(err)
+		}
+		fmt.Fprintf(os.Stdout, "%s\n", out)
+		return
+	}
+
+	for _, repository := range repositories {
+		fmt.Fprintf(os.Stdout, "%s\n", repository.FullName)
+	}
+}
+
+// runCheckはJWTで認証できることを確認し、Appのslugとインストール数を出力します。
+// インストールアクセストークンは取得しません。
+func runCheck(args *token.AccessToken, opts *options) {
+	closeSigner := setupSigner(args, opts)
+	defer closeSigner()
+
+	app, err := args.GetApp()
 	if err != nil {
-		return nil, err
+		exitWithError(addHint(err))
 	}
 
-	return &ss, nil
+	if opts.output == "json" {
+		out, err := json.Marshal(app)
+		if err != nil {
+			exitWithError(err)
+		}
+		fmt.Fprintf(os.Stdout, "%s\n", out)
+		return
+	}
+
+	fmt.Fprintf(os.Stdout, "app: %s (id %d)\n", app.Slug, app.Id)
+	fmt.Fprintf(os.Stdout, "installations: %d\n", app.InstallationsCount)
 }
 
-// getAccessTokenEndpointはgithubからアクセストークンを取得するためのエンドポイントを返します。
-func (args *AccessToken) getAccessTokenEndpoint(privateKey *rsa.PrivateKey) (*string, error) {
-	// get installation api
-	authorization, err := args.getAuthorization(privateKey)
+// runAllInstallationsはAppのすべてのインストールのトークンを取得してjsonの配列で出力します。
+// 一部のインストールで失敗した場合は取得できたトークンのみを出力し、失敗したインストールを書き出して終了します。
+func runAllInstallations(args *token.AccessToken, opts *options) {
+	closeSigner := setupSigner(args, opts)
+	defer closeSigner()
+
+	setupRequest(args, opts)
+
+	// 一部のインストールで失敗した場合も取得できたトークンは出力してから失敗として終了する
+	tokens, err := args.GetAllInstallations()
+	var allErr *token.AllInstallationsError
+	if err != nil && !errors.As(err, &allErr) {
+		exitWithError(addHint(err))
+	}
+	for i := range tokens {
+		logger.addSecret(tokens[i].Token)
+
+		expiresAt, parseErr := tokens[i].ExpiresAtTime()
+		if parseErr != nil {
+			exitWithError(parseErr)
+		}
+		tokens[i].ExpiresAt = formatExpiry(opts, expiresAt)
+	}
+
+	out, marshalErr := json.Marshal(tokens)
+	if marshalErr != nil {
+		exitWithError(marshalErr)
+	}
+	fmt.Fprintf(os.Stdout, "%s\n", out)
+
 	if err != nil {
-		return nil, err
+		exitWithError(addHint(err))
+	}
+}
+
+// runDiffPermissionsはトークンを取得し、要求した権限と付与された権限の違いを表にして出力します。
+// 要求した権限の一部が付与されなかった場合は認証の失敗として終了します。
+// トークン自体は出力しないため、比較が終わったら失効させます。
+func runDiffPermissions(args *token.AccessToken, opts *options) {
+	closeSigner := setupSigner(args, opts)
+	defer closeSigner()
+
+	if args.InstallationId == 0 && args.AccountName == "" && args.RepositoryLookupId == 0 {
+		checkError(args.OrganizationName, "org")
 	}
 
-	installationApiResponse := InstallationApiResponse{}
-	installationApiUrl := fmt.Sprintf("https://api.github.com/repos/%s/installation", args.getRepoName())
-	err = send(authorization, "GET", &installationApiUrl, &installationApiResponse)
+	setupRequest(args, opts)
+
+	// 付与されなかった権限も表に含めるため、取得の時点では失敗させない
+	// 表が結果そのものなので、付与されなかった権限の警告も重ねて出力しない
+	args.SkipPermissionsCheck = true
+
+	result, err := args.Get()
 	if err != nil {
-		return nil, err
+		exitWithError(addHint(err))
+	}
+	logger.addSecret(result.Token)
+
+	// キャッシュしたトークンは他の呼び出しで再利用されるため失効させない
+	if args.CacheFile == "" {
+		if err := args.Revoke(result.Token); err != nil {
+			logger.Warn("failed to revoke the token", token.Field{Key: "error", Value: err.Error()})
+		}
+	}
+
+	diffs := token.DiffPermissions(args.Permissions, result.Permissions)
+
+	if opts.output == "json" {
+		out, err := json.Marshal(diffs)
+		if err != nil {
+			exitWithError(err)
+		}
+		fmt.Fprintf(os.Stdout, "%s\n", out)
+	} else {
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "PERMISSION\tREQUESTED\tGRANTED\tSTATUS")
+		for _, diff := range diffs {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", diff.Name, orDash(diff.Requested), orDash(diff.Granted), diff.Status)
+		}
+		writer.Flush()
+	}
+
+	notGranted := []string{}
+	for _, diff := range diffs {
+		if diff.Status == "missing" || diff.Status == "downgraded" {
+			notGranted = append(notGranted, diff.Name)
+		}
 	}
+	if len(notGranted) > 0 {
+		exitWithError(&token.Error{Kind: token.KindAuth, Err: fmt.Errorf("requested permissions were not granted: %s", strings.Join(notGranted, ", "))})
+	}
+}
 
-	return installationApiResponse.AccessTokensUrl, nil
+// orDashは空の値を表で分かるよう"-"にします。
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
 }
 
-// getAccessTokenはgithubからアクセストークンを取得して返します。
-func (args *AccessToken) getAccessToken(privateKey *rsa.PrivateKey, endpoint *string) (*string, error) {
-	authorization, err := args.getAuthorization(privateKey)
+// runUserTokenはデバイスフローでユーザーアクセストークンを取得して出力します。
+// ユーザーが認可するまで待つため、表示するURLとコードは標準エラー出力に書き出します。
+func runUserToken(args *token.AccessToken, opts *options) {
+	code, err := args.RequestDeviceCode()
 	if err != nil {
-		return nil, err
+		exitWithError(err)
 	}
 
-	accessTokenApiResponse := AccessTokenApiResponse{}
-	err = send(authorization, "POST", endpoint, &accessTokenApiResponse)
+	// ユーザーが入力するコードが無ければ認可できないため、-quietでも書き出す
+	logger.log("info", "open the verification url and enter the user code to authorize the app", []token.Field{
+		{Key: "verification_uri", Value: code.VerificationUri},
+		{Key: "user_code", Value: code.UserCode},
+	})
+
+	result, err := args.PollUserToken(context.Background(), code)
 	if err != nil {
-		return nil, err
+		exitWithError(err)
+	}
+	logger.addSecret(result.Token)
+	logger.addSecret(result.RefreshToken)
+
+	if opts.output == "json" {
+		out, err := json.Marshal(result)
+		if err != nil {
+			exitWithError(err)
+		}
+		fmt.Fprintf(os.Stdout, "%s\n", out)
+		return
 	}
 
-	return &accessTokenApiResponse.Token, nil
+	if opts.noNewline {
+		fmt.Fprint(os.Stdout, result.Token)
+		return
+	}
+	fmt.Fprintf(os.Stdout, "%s\n", result.Token)
 }
 
-// Getはアクセストークンを取得して返します。
-func (args *AccessToken) Get() (*string, error) {
-	privateKey, err := args.readPrivateKey()
+// runTtlはトークンの有効期限までの残り秒数を出力します。
+// -expires-atが指定されている場合はその時刻まで、それ以外はトークンを取得してその有効期限までの秒数です。
+// -expires-atは-tokenで渡したトークンを取得した際のexpires_atを想定しています。
+func runTtl(args *token.AccessToken, opts *options) {
+	expiresAt := opts.expiresAt
+
+	// 渡されたトークンはログで伏せるために読み込むが、有効期限は-expires-atから求める
+	if opts.token != "" && expiresAt != "" {
+		if _, err := readToken(opts, false); err != nil {
+			exitWithError(err)
+		}
+	}
+
+	if expiresAt == "" {
+		// 既存のトークンの有効期限はトークンだけからは分からない
+		if opts.token != "" {
+			exitWithError(usageError("expires-at is required when token is given"))
+		}
+
+		closeSigner := setupSigner(args, opts)
+		defer closeSigner()
+
+		if args.InstallationId == 0 && args.AccountName == "" && args.RepositoryLookupId == 0 {
+			checkError(args.OrganizationName, "org")
+		}
+
+		setupRequest(args, opts)
+
+		result, err := args.Get()
+		if err != nil {
+			exitWithError(addHint(err))
+		}
+		logger.addSecret(result.Token)
+		expiresAt = result.ExpiresAt
+	}
+
+	expires, err := time.Parse(time.RFC3339, expiresAt)
 	if err != nil {
-		return nil, err
+		exitWithError(usageError("malformed expires-at %q: expected RFC 3339 time", expiresAt))
+	}
+
+	// 有効期限を過ぎている場合は0にする
+	ttl := int64(time.Until(expires).Seconds())
+	if ttl < 0 {
+		ttl = 0
 	}
 
-	endpoint, err := args.getAccessTokenEndpoint(privateKey)
+	if opts.output == "json" {
+		out, err := json.Marshal(struct {
+			ExpiresAt  string `json:"expires_at"`
+			TtlSeconds int64  `json:"ttl_seconds"`
+		}{formatExpiry(opts, expires), ttl})
+		if err != nil {
+			exitWithError(err)
+		}
+		fmt.Fprintf(os.Stdout, "%s\n", out)
+		return
+	}
+
+	// 残り時間の形式のみテキストの出力にも適用し、時刻の形式の場合は秒数を出力する
+	if opts.expiryFormat == "relative" {
+		fmt.Fprintf(os.Stdout, "%s\n", formatExpiry(opts, expires))
+		return
+	}
+	fmt.Fprintf(os.Stdout, "%d\n", ttl)
+}
+
+// readTokenは-tokenで指定されたトークンを返します。
+// "-"の場合と、allowEmptyがtrueで指定されていない場合は標準入力から読み込みます。
+// トークンをコマンドラインに書くとpsなどで他のユーザーから見えるため、標準入力から渡すことを推奨します。
+func readToken(opts *options, allowEmpty bool) (string, error) {
+	if opts.token != "" && opts.token != "-" {
+		logger.addSecret(opts.token)
+		return opts.token, nil
+	}
+	if opts.token == "" && !allowEmpty {
+		return "", usageError("token is not set")
+	}
+
+	input, err := ioutil.ReadAll(os.Stdin)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+
+	// 末尾の改行は取り除くが、複数のトークンなど余分な内容は誤りとして扱う
+	value := strings.TrimSpace(string(input))
+	if value == "" {
+		return "", usageError("token is not set")
 	}
+	if strings.ContainsAny(value, " \t\r\n") {
+		return "", usageError("token read from stdin must be a single line without spaces")
+	}
+	logger.addSecret(value)
+
+	return value, nil
+}
 
-	token, err := args.getAccessToken(privateKey, endpoint)
+// runRevokeはトークンを失効させます。
+func runRevoke(args *token.AccessToken, opts *options) {
+	value, err := readToken(opts, true)
 	if err != nil {
-		return nil, err
+		exitWithError(err)
 	}
 
-	return token, nil
+	err = args.Revoke(value)
+	if err != nil {
+		exitWithError(err)
+	}
 }
 
 func main() {
-	args := AccessToken{
-		AppId:            flag.String("app", "", "AppID on Github Apps"),
-		PemFilePath:      flag.String("pem", "", "path to pemfile of private key"),
-		OrganizationName: flag.String("org", "", "owner or organization name of the repository"),
-		RepositoryName:   flag.String("repo", "", "repository name"),
+	// 最初の引数がフラグでない場合はサブコマンドとして扱う
+	command := ""
+	arguments := os.Args[1:]
+	if len(arguments) > 0 && !strings.HasPrefix(arguments[0], "-") {
+		command, arguments = arguments[0], arguments[1:]
+	}
+
+	args := token.AccessToken{Logger: logger}
+	opts := options{}
+
+	flag.StringVar(&opts.output, "output", "text", "output format: text, json, json-full, git-credential, jwt, export, actions or netrc")
+	flag.BoolVar(&opts.noNewline, "no-newline", false, "omit the trailing newline after the token in text output")
+	flag.StringVar(&opts.exportVar, "export-var", "GITHUB_TOKEN", "name of environment variable set by output export")
+	flag.StringVar(&opts.pemEnv, "pem-env", "GITHUB_APP_PRIVATE_KEY", "name of environment variable holding the private key, used when pem is not set")
+	flag.StringVar(&opts.permissions, "permissions", "", "permissions of the token as key:level pairs, e.g. contents:read,pull_requests:write")
+	flag.BoolVar(&opts.leastPrivilege, "least-privilege", false, "request only contents:read, limited to repo if given, and fail if the token is granted anything more than contents:read and metadata:read")
+	flag.StringVar(&args.SingleFile, "single-file", "", "path of the file accessed with the single_file permission, requires single_file:read or single_file:write in permissions")
+	flag.StringVar(&opts.repositories, "repositories", "", "comma separated repository names the token can access, e.g. repo1,repo2")
+	flag.BoolVar(&args.StrictPermissions, "strict-permissions", false, "fail instead of warning when a requested permission is not granted or granted at a lower level")
+	flag.StringVar(&opts.repositoryIds, "repository-ids", "", "comma separated repository ids the token can access, stable across renames, e.g. 123,456")
+	flag.BoolVar(&opts.printInstallationId, "print-installation-id", false, "print the installation id to stderr")
+	flag.StringVar(&opts.outputFile, "output-file", "", "write only the token to the file with 0600 permissions instead of stdout. with output netrc, the entry of the host is merged into the file")
+	flag.BoolVar(&opts.outputFileNewline, "output-file-newline", false, "append a trailing newline to the token written by output-file")
+	flag.BoolVar(&opts.watch, "watch", false, "keep running and rewrite output-file with a new token refresh-margin before the current one expires, until SIGINT or SIGTERM")
+	flag.StringVar(&opts.config, "config", "", "path to config file of default flag values (default ./"+defaultConfigFile+" if exists)")
+	flag.StringVar(&opts.envFile, "env-file", "", "path to .env file of KEY=VALUE lines loaded into the environment, variables already set are not overridden")
+	flag.BoolVar(&opts.version, "version", false, "print version information and exit")
+	flag.BoolVar(&opts.printRateLimit, "print-rate-limit", false, "log the remaining rate limit quota to stderr after each API call")
+	flag.BoolVar(&opts.printJwtClaims, "print-jwt-claims", false, "log the claims (iss, iat and exp) of each signed JWT as a JSON object for debugging, even with quiet. the signature is never printed")
+	flag.BoolVar(&opts.dryRun, "dry-run", false, "print the signed JWT and exit without calling GitHub API")
+	flag.BoolVar(&logger.verbose, "verbose", false, "log HTTP requests and responses to stderr, tokens are always redacted")
+	flag.BoolVar(&logger.quiet, "quiet", false, "suppress all stderr output except errors, takes precedence over verbose")
+	flag.BoolVar(&logger.maskSecrets, "mask-token-in-errors", true, "replace tokens and JWTs in logs and error messages with ***, disable only for debugging")
+	flag.StringVar(&logger.format, "log-format", "text", "format of logs written to stderr: text or json")
+	flag.StringVar(&opts.expiryFormat, "expiry-format", "rfc3339", "format of expires_at in json output, all-installations and ttl: rfc3339, unix (epoch seconds) or relative (remaining time, e.g. 59m12s)")
+	flag.DurationVar(&opts.minTtl, "min-ttl", 0, "fail if the token expires sooner than this, e.g. 45m. 0 disables the check")
+	flag.StringVar(&opts.expiresAt, "expires-at", "", "expires_at of the token in RFC 3339 for ttl, a new token is minted if not set")
+	flag.StringVar(&opts.token, "token", "", "existing installation token for revoke, list-repos and ttl, or - to read it from stdin. revoke reads stdin if not set")
+	flag.StringVar(&args.AppId, "app", "", "AppID or Client ID on Github Apps (default $GITHUB_APP_ID)")
+	flag.StringVar(&args.ClientId, "client-id", "", "Client ID on Github Apps used as the JWT issuer instead of app, e.g. Iv1.abc123 (default $GITHUB_APP_CLIENT_ID)")
+	flag.StringVar(&args.JwtIssuer, "jwt-iss", "", "iss claim of the JWT used instead of app or client-id, the installation lookup is not affected")
+	flag.StringVar(&args.ApiUrl, "api-url", "", "base url of GitHub API, e.g. https://ghe.example.com/api/v3 (default $GITHUB_API_URL or "+token.DefaultApiUrl+")")
+	flag.StringVar(&args.WebUrl, "web-url", "", "base url of GitHub used by user-token, e.g. https://ghe.example.com (default $GITHUB_SERVER_URL or "+token.DefaultWebUrl+")")
+	flag.StringVar(&args.PemFilePath, "pem", "", "path to pemfile of private key, or - to read from stdin. comma separated files are tried in order when a key is rejected, e.g. new.pem,old.pem")
+	flag.StringVar(&opts.pemInline, "pem-inline", "", "PEM contents of private key given directly, used when pem and the pem-env variable are not set. insecure, for testing only")
+	flag.StringVar(&args.PrivateKeyBase64, "pem-base64", "", "base64 encoded PEM of private key, used when pem is not set (default $GITHUB_APP_PRIVATE_KEY_BASE64)")
+	flag.StringVar(&opts.pkcs11.Module, "pkcs11", "", "path to PKCS#11 module to sign the JWT with a key in HSM instead of pem")
+	flag.UintVar(&opts.pkcs11.Slot, "pkcs11-slot", 0, "slot id of the PKCS#11 token holding the key")
+	flag.StringVar(&opts.pkcs11.Label, "pkcs11-label", "", "label of the private key in the PKCS#11 token")
+	flag.StringVar(&opts.pkcs11.Pin, "pkcs11-pin", "", "user PIN of the PKCS#11 token (default $GITHUB_APP_PKCS11_PIN)")
+	flag.StringVar(&args.Passphrase, "passphrase", "", "passphrase of encrypted private key (default $GITHUB_APP_KEY_PASSPHRASE)")
+	flag.IntVar(&args.InstallationId, "installation-id", 0, "installation id of the app, skips looking up the installation by org and repo")
+	flag.StringVar(&args.OrganizationName, "org", "", "owner or organization name of the repository")
+	flag.StringVar(&args.TokenUrl, "token-url", "", "url template to request the token instead of access_tokens_url of the installation, {installation_id} is replaced and a leading / is relative to api-url, e.g. /app/installations/{installation_id}/access_tokens")
+	flag.StringVar(&args.FallbackOrganizationName, "fallback-org", "", "owner to look up the installation with when org returns 404, e.g. the new owner of a repository being transferred")
+	flag.StringVar(&args.AccountName, "account", "", "login of the user or organization the app is installed on, looked up as an org first and then as a user, instead of org and repo")
+	flag.IntVar(&args.RepositoryLookupId, "repo-lookup-id", 0, "repository id to look up the installation by instead of org and repo, stable across renames")
+	flag.StringVar(&args.RepositoryName, "repo", "", "repository name or owner/repo, omit to look up the installation of the org instead of the repository")
+	flag.IntVar(&args.Concurrency, "concurrency", token.DefaultConcurrency, "number of installations to get tokens for in parallel in all-installations")
+	flag.IntVar(&args.MaxRetries, "max-retries", 3, "max number of retries on 5xx responses and network errors")
+	flag.DurationVar(&args.RetryBaseDelay, "retry-base-delay", time.Second, "base delay of exponential backoff between retries")
+	flag.BoolVar(&args.RetryDns, "retry-dns", false, "also retry temporary DNS resolution failures, which fail immediately by default")
+	flag.DurationVar(&args.RetryMaxDelay, "retry-max-delay", token.DefaultRetryMaxDelay, "max delay between retries, each delay is chosen randomly up to the exponential backoff")
+	flag.DurationVar(&args.MaxRetryWait, "max-retry-wait", time.Minute, "max time to wait for Retry-After when rate limited")
+	flag.DurationVar(&args.Timeout, "timeout", 30*time.Second, "timeout of each HTTP request including reading the response body")
+	flag.DurationVar(&args.Deadline, "deadline", 0, "total time budget for getting the token including retries, 0 means no limit. each request is also bounded by timeout")
+	flag.StringVar(&args.CaCertPath, "ca-cert", "", "path to PEM bundle of CA certificates to verify the server, default is the system pool")
+	flag.BoolVar(&args.InsecureSkipVerify, "insecure-skip-verify", false, "skip verification of the server certificate, for testing only")
+	flag.DurationVar(&args.JwtLifetime, "jwt-lifetime", token.DefaultJwtLifetime, "lifetime of the JWT, up to 10m")
+	flag.DurationVar(&args.JwtClockSkew, "jwt-clock-skew", token.DefaultJwtClockSkew, "how far to backdate the iat claim of the JWT to allow for clock drift")
+	flag.BoolVar(&args.AllowClockSkewRetry, "allow-clock-skew-retry", false, "retry once with a further backdated iat when GitHub rejects the JWT because iat is in the future")
+	flag.StringVar(&args.CacheFile, "cache-file", "", "path to cache file to reuse the token until refresh-margin before it expires")
+	flag.DurationVar(&args.RefreshMargin, "refresh-margin", token.DefaultRefreshWindow, "how long before expiry a cached token is considered stale and re-minted, less than 1h")
+	flag.IntVar(&args.PerPage, "per-page", token.MaxPerPage, "number of results per page when listing, up to 100")
+	flag.StringVar(&args.Accept, "accept", token.DefaultAccept, "Accept header sent to GitHub API, e.g. a preview media type")
+	flag.StringVar(&args.ApiVersion, "api-version", token.DefaultApiVersion, "X-GitHub-Api-Version header sent to GitHub API")
+	flag.Var(&opts.headers, "header", "additional header sent to GitHub as 'Name: Value', can be repeated. Authorization, Accept and Content-Type cannot be overridden")
+	flag.StringVar(&args.UserAgent, "user-agent", "", "User-Agent header sent to GitHub API (default github-app-token/<version>)")
+	flag.Int64Var(&args.MaxResponseBytes, "max-response-bytes", token.DefaultMaxResponseBytes, "max size of a response body in bytes, larger responses are rejected")
+	flag.BoolVar(&opts.logApp, "log-app", false, "with verbose, log the slug and owner of the app from GET /app before requesting the token")
+	flag.BoolVar(&args.ValidateApp, "validate-app", false, "check that the private key belongs to the app by calling GET /app before requesting the token")
+	flag.StringVar(&args.Proxy, "proxy", "", "proxy url, e.g. http://proxy.example.com:8080 (default $HTTPS_PROXY or $HTTP_PROXY)")
+	flag.CommandLine.Parse(arguments)
+
+	if opts.version {
+		fmt.Fprintf(os.Stdout, "github-app-token %s (commit %s, built %s, %s)\n", version, commit, date, runtime.Version())
+		return
 	}
-	flag.Parse()
 
-	args.CheckError(args.AppId, "app")
-	args.CheckError(args.PemFilePath, "pem")
-	args.CheckError(args.OrganizationName, "org")
-	args.CheckError(args.RepositoryName, "repo")
+	rand.Seed(time.Now().UnixNano())
 
-	message, err := args.Get()
+	// 設定ファイルの値はコマンドラインのフラグより優先度が低く、環境変数より優先度が高い
+	if path := getConfigPath(opts.config); path != "" {
+		err := applyConfig(path)
+		if err != nil {
+			exitWithError(&token.Error{Kind: token.KindInvalidArgument, Err: err})
+		}
+	}
+
+	// 環境変数の値を使用する前に読み込み、既に設定されている環境変数を優先する
+	if opts.envFile != "" {
+		err := loadEnvFile(opts.envFile)
+		if err != nil {
+			exitWithError(&token.Error{Kind: token.KindInvalidArgument, Err: err})
+		}
+	}
+
+	// 以降のログを指定された形式で出力できるよう最初に確認する
+	if format := logger.format; !logFormats[format] {
+		logger.format = "text"
+		exitWithError(usageError("unknown log format: %s", format))
+	}
+
+	if args.InsecureSkipVerify {
+		logger.Warn("TLS certificate verification is disabled by -insecure-skip-verify. Do not use this in production.")
+	}
+
+	if !outputFormats[opts.output] {
+		exitWithError(usageError("unknown output format: %s", opts.output))
+	}
+	if opts.minTtl < 0 || opts.minTtl >= token.TokenLifetime {
+		exitWithError(usageError("min-ttl must be between 0 and %s: %s", token.TokenLifetime, opts.minTtl))
+	}
+	if !expiryFormats[opts.expiryFormat] {
+		exitWithError(usageError("unknown expiry format: %s", opts.expiryFormat))
+	}
+	if !exportVarPattern.MatchString(opts.exportVar) {
+		exitWithError(usageError("invalid export-var: %s", opts.exportVar))
+	}
+	// all-installationsはインストールごとに付与された権限を確認できないため、権限を超えたトークンを出力しないよう拒否する
+	if opts.leastPrivilege && command == "all-installations" {
+		exitWithError(usageError("least-privilege cannot be used with command %s", command))
+	}
+	if opts.watch {
+		if command != "" {
+			exitWithError(usageError("watch cannot be used with command %s", command))
+		}
+		if opts.outputFile == "" {
+			exitWithError(usageError("watch requires output-file"))
+		}
+		if opts.output == "git-credential" || opts.output == "jwt" || opts.dryRun {
+			exitWithError(usageError("watch cannot be used with output %s or dry-run", opts.output))
+		}
+	}
+
+	if args.UserAgent == "" {
+		args.UserAgent = token.DefaultUserAgent + "/" + version
+	}
+
+	args.Headers = parseHeaders(opts.headers)
+
+	if opts.printRateLimit {
+		args.RateLimitFunc = logRateLimit
+	}
+	if opts.printJwtClaims {
+		args.JwtFunc = printJwtClaims
+	}
+
+	// 出力されないログのためにAPIを呼び出さないよう、-verboseの場合に限る
+	args.LogApp = opts.logApp && logger.verbose && !logger.quiet
+
+	// 環境変数はフラグが指定されていない場合に使用する
+	if args.AppId == "" {
+		args.AppId = os.Getenv("GITHUB_APP_ID")
+	}
+	if args.ClientId == "" {
+		args.ClientId = os.Getenv("GITHUB_APP_CLIENT_ID")
+	}
+	if args.ApiUrl == "" {
+		if env := os.Getenv("GITHUB_API_URL"); env != "" {
+			logger.Debug("using api url from GITHUB_API_URL", token.Field{Key: "api_url", Value: env})
+			args.ApiUrl = env
+		}
+	}
+	if args.WebUrl == "" {
+		args.WebUrl = os.Getenv("GITHUB_SERVER_URL")
+	}
+	if args.PrivateKeyBase64 == "" {
+		args.PrivateKeyBase64 = os.Getenv("GITHUB_APP_PRIVATE_KEY_BASE64")
+	}
+	if args.Passphrase == "" {
+		args.Passphrase = os.Getenv("GITHUB_APP_KEY_PASSPHRASE")
+	}
+	if opts.pkcs11.Pin == "" {
+		opts.pkcs11.Pin = os.Getenv("GITHUB_APP_PKCS11_PIN")
+	}
+
+	// GitHub Actionsではワークフローを実行しているリポジトリを使用する
+	// list-installationsでは-orgの有無で一覧の対象が変わるため使用しない
+	if command != "list-installations" && command != "all-installations" && args.OrganizationName == "" && args.RepositoryName == "" && args.AccountName == "" && args.RepositoryLookupId == 0 && args.InstallationId == 0 {
+		if env := os.Getenv("GITHUB_REPOSITORY"); env != "" {
+			logger.Debug("using repository from GITHUB_REPOSITORY", token.Field{Key: "repository", Value: env})
+			args.RepositoryName = env
+		}
+	}
+
+	err := splitRepository(&args)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error occurred: %v\n", err)
-		os.Exit(1)
+		exitWithError(err)
+	}
+	if args.AccountName != "" && (args.OrganizationName != "" || args.RepositoryName != "") {
+		exitWithError(usageError("account cannot be used with org or repo"))
+	}
+	if args.RepositoryLookupId != 0 && (args.OrganizationName != "" || args.RepositoryName != "" || args.AccountName != "") {
+		exitWithError(usageError("repo-lookup-id cannot be used with org, repo or account"))
+	}
+	if args.FallbackOrganizationName != "" && args.OrganizationName == "" {
+		exitWithError(usageError("fallback-org requires org"))
 	}
 
-	fmt.Fprintf(os.Stdout, "%s\n", *message)
+	switch command {
+	case "":
+		runGet(&args, &opts, flag.Arg(0))
+	case "revoke":
+		runRevoke(&args, &opts)
+	case "list-installations":
+		runListInstallations(&args, &opts)
+	case "list-repos":
+		runListRepos(&args, &opts)
+	case "check":
+		runCheck(&args, &opts)
+	case "all-installations":
+		runAllInstallations(&args, &opts)
+	case "ttl":
+		runTtl(&args, &opts)
+	case "user-token":
+		runUserToken(&args, &opts)
+	case "diff-permissions":
+		runDiffPermissions(&args, &opts)
+	default:
+		exitWithError(usageError("unknown command: %s", command))
+	}
 }
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mainArgumentsEnvはrunMainで起動したプロセスにmainの引数を渡す環境変数です。
+const mainArgumentsEnv = "GITHUB_APP_TOKEN_TEST_MAIN"
+
+// TestRunMainはrunMainで起動されたプロセスでmainを実行します。通常のテストでは何もしません。
+// mainは終了時にos.Exitを呼び出し、フラグもプロセス全体で共有するため、別のプロセスで実行します。
+func TestRunMain(t *testing.T) {
+	value := os.Getenv(mainArgumentsEnv)
+	if value == "" {
+		return
+	}
+
+	var arguments []string
+	if err := json.Unmarshal([]byte(value), &arguments); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	os.Args = append([]string{"github-app-token"}, arguments...)
+	main()
+	os.Exit(0)
+}
+
+// mainCommandはargumentsを引数としてmainを実行するコマンドを返します。
+// 実行する環境の設定の影響を受けないよう、GitHubとプロキシの環境変数は引き継ぎません。
+func mainCommand(t *testing.T, arguments ...string) *exec.Cmd {
+	t.Helper()
+
+	value, err := json.Marshal(arguments)
+	if err != nil {
+		t.Fatalf("failed to encode arguments: %v", err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRunMain$")
+	for _, env := range os.Environ() {
+		name := strings.ToUpper(strings.SplitN(env, "=", 2)[0])
+		if strings.HasPrefix(name, "GITHUB_") || strings.HasSuffix(name, "_PROXY") {
+			continue
+		}
+		cmd.Env = append(cmd.Env, env)
+	}
+	cmd.Env = append(cmd.Env, mainArgumentsEnv+"="+string(value))
+	return cmd
+}
+
+// runMainはargumentsを引数としてmainを実行し、標準出力、標準エラー出力と終了コードを返します。
+func runMain(t *testing.T, arguments ...string) (string, string, int) {
+	t.Helper()
+
+	return runMainWithInput(t, "", arguments...)
+}
+
+// runMainWithInputはinputを標準入力に渡してrunMainと同じくmainを実行します。
+func runMainWithInput(t *testing.T, input string, arguments ...string) (string, string, int) {
+	t.Helper()
+
+	cmd := mainCommand(t, arguments...)
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = strings.NewReader(input), stdout, stderr
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return stdout.String(), stderr.String(), exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatalf("failed to run main: %v", err)
+	}
+	return stdout.String(), stderr.String(), 0
+}
+
+// writeTestKeyはテスト用のRSA鍵を生成してPEMファイルに書き出し、そのパスを返します。
+func writeTestKey(t *testing.T) string {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "key.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	return path
+}
+
+// testApiはトークンの発行と失効を受け付けるGitHub APIのモックです。
+// 発行したトークンには順にgrantsの権限を付与し、用意した数より多く発行した場合は最後の権限を繰り返します。
+type testApi struct {
+	server *httptest.Server
+	grants []map[string]string
+
+	mutex   sync.Mutex
+	bodies  []map[string]interface{}
+	revoked []string
+}
+
+// newTestApiはtestApiを起動します。
+func newTestApi(t *testing.T, grants ...map[string]string) *testApi {
+	t.Helper()
+
+	api := &testApi{grants: grants}
+	api.server = httptest.NewServer(http.HandlerFunc(api.handle))
+	t.Cleanup(api.server.Close)
+	return api
+}
+
+func (api *testApi) handle(w http.ResponseWriter, r *http.Request) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/app/installations/1/access_tokens":
+		body := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		api.bodies = append(api.bodies, body)
+
+		grant := api.grants[len(api.grants)-1]
+		if len(api.bodies) <= len(api.grants) {
+			grant = api.grants[len(api.bodies)-1]
+		}
+
+		// -watchが1秒後に取得し直すよう、既定の-refresh-marginの1秒後に有効期限が切れるトークンを発行する
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":       testToken(len(api.bodies)),
+			"expires_at":  time.Now().Add(5*time.Minute + time.Second).UTC().Format(time.RFC3339),
+			"permissions": grant,
+		})
+	case r.Method == "DELETE" && r.URL.Path == "/installation/token":
+		api.revoked = append(api.revoked, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"message":"Not Found"}`)
+	}
+}
+
+// requestsはトークンの発行を要求された本文を返します。
+func (api *testApi) requests() []map[string]interface{} {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	return append([]map[string]interface{}{}, api.bodies...)
+}
+
+// revokedTokensは失効させたトークンを返します。
+func (api *testApi) revokedTokens() []string {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	return append([]string{}, api.revoked...)
+}
+
+// testTokenはtestApiがn番目に発行するトークンを返します。
+func testToken(n int) string {
+	return fmt.Sprintf("ghs_%036d", n)
+}
+
+func TestLeastPrivilege(t *testing.T) {
+	keyPath := writeTestKey(t)
+
+	tests := []struct {
+		name        string
+		arguments   []string
+		grant       map[string]string
+		wantBody    string
+		wantCode    int
+		wantError   string
+		wantRevoked bool
+	}{
+		{
+			name:     "contents read",
+			grant:    map[string]string{"contents": "read"},
+			wantBody: `{"permissions":{"contents":"read"}}`,
+		},
+		{
+			name:      "limited to repo",
+			arguments: []string{"-org", "octocat", "-repo", "hello-world"},
+			grant:     map[string]string{"contents": "read"},
+			wantBody:  `{"permissions":{"contents":"read"},"repositories":["hello-world"]}`,
+		},
+		{
+			name:     "metadata read",
+			grant:    map[string]string{"contents": "read", "metadata": "read"},
+			wantBody: `{"permissions":{"contents":"read"}}`,
+		},
+		{
+			name:        "contents write",
+			grant:       map[string]string{"contents": "write", "metadata": "read"},
+			wantBody:    `{"permissions":{"contents":"read"}}`,
+			wantCode:    3,
+			wantError:   "token is not least privilege, also granted contents:write",
+			wantRevoked: true,
+		},
+		{
+			name:        "extra permission",
+			grant:       map[string]string{"contents": "read", "issues": "write"},
+			wantBody:    `{"permissions":{"contents":"read"}}`,
+			wantCode:    3,
+			wantError:   "token is not least privilege, also granted issues:write",
+			wantRevoked: true,
+		},
+		{
+			name:      "with permissions",
+			arguments: []string{"-permissions", "contents:write"},
+			wantCode:  2,
+			wantError: "least-privilege cannot be used with permissions",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := newTestApi(t, test.grant)
+
+			arguments := append([]string{"-app", "12345", "-pem", keyPath, "-api-url", api.server.URL, "-installation-id", "1", "-least-privilege"}, test.arguments...)
+			stdout, stderr, code := runMain(t, arguments...)
+
+			if code != test.wantCode {
+				t.Fatalf("exit code = %d, want %d: %s", code, test.wantCode, stderr)
+			}
+			if !strings.Contains(stderr, test.wantError) {
+				t.Errorf("stderr = %q, want to contain %q", stderr, test.wantError)
+			}
+
+			requests := api.requests()
+			if test.wantBody == "" {
+				if len(requests) != 0 {
+					t.Errorf("token is requested: %v", requests)
+				}
+				return
+			}
+			if len(requests) != 1 {
+				t.Fatalf("token is requested %d times, want 1", len(requests))
+			}
+			if body, _ := json.Marshal(requests[0]); string(body) != test.wantBody {
+				t.Errorf("request body = %s, want %s", body, test.wantBody)
+			}
+
+			revoked := api.revokedTokens()
+			if test.wantRevoked {
+				if len(revoked) != 1 || revoked[0] != testToken(1) {
+					t.Errorf("revoked = %v, want %s", revoked, testToken(1))
+				}
+				if strings.Contains(stdout, testToken(1)) {
+					t.Errorf("token is printed: %s", stdout)
+				}
+				return
+			}
+			if len(revoked) != 0 {
+				t.Errorf("revoked = %v, want none", revoked)
+			}
+			if strings.TrimSpace(stdout) != testToken(1) {
+				t.Errorf("stdout = %q, want %s", stdout, testToken(1))
+			}
+		})
+	}
+}
+
+func TestLeastPrivilegeWithAllInstallations(t *testing.T) {
+	api := newTestApi(t, map[string]string{"contents": "read"})
+
+	_, stderr, code := runMain(t, "all-installations", "-app", "12345", "-pem", writeTestKey(t), "-api-url", api.server.URL, "-least-privilege")
+
+	if code != 2 {
+		t.Fatalf("exit code = %d, want 2: %s", code, stderr)
+	}
+	if want := "least-privilege cannot be used with command all-installations"; !strings.Contains(stderr, want) {
+		t.Errorf("stderr = %q, want to contain %q", stderr, want)
+	}
+	if requests := api.requests(); len(requests) != 0 {
+		t.Errorf("token is requested: %v", requests)
+	}
+}
+
+func TestLeastPrivilegeWithWatch(t *testing.T) {
+	// 2回目に取得し直したトークンだけ権限を超えて付与する
+	api := newTestApi(t, map[string]string{"contents": "read"}, map[string]string{"contents": "write"})
+	outputFile := filepath.Join(t.TempDir(), "token")
+
+	cmd := mainCommand(t, "-app", "12345", "-pem", writeTestKey(t), "-api-url", api.server.URL, "-installation-id", "1", "-least-privilege", "-watch", "-output-file", outputFile)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to run main: %v", err)
+	}
+	defer func() {
+		cmd.Process.Signal(os.Interrupt)
+		cmd.Wait()
+	}()
+
+	// 権限を超えたトークンを失効させた後、1分待って再試行するまでは最初のトークンが残る
+	deadline := time.Now().Add(10 * time.Second)
+	for len(api.revokedTokens()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("token is not revoked, requested %d times", len(api.requests()))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if revoked := api.revokedTokens(); len(revoked) != 1 || revoked[0] != testToken(2) {
+		t.Errorf("revoked = %v, want %s", revoked, testToken(2))
+	}
+	data, err := ioutil.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("failed to read output file: %v", err)
+	}
+	if strings.TrimSpace(string(data)) != testToken(1) {
+		t.Errorf("output file = %q, want %s", data, testToken(1))
+	}
+}
+
+func TestGitCredential(t *testing.T) {
+	keyPath := writeTestKey(t)
+
+	tests := []struct {
+		name       string
+		credential func(host string) string
+		want       bool
+	}{
+		{name: "api host", credential: func(host string) string { return "protocol=https\nhost=" + host + "\n\n" }, want: true},
+		{name: "other host", credential: func(host string) string { return "protocol=https\nhost=evil.example.com\n\n" }},
+		{name: "other port", credential: func(host string) string { return "protocol=https\nhost=" + host + "0\n\n" }},
+		{name: "http", credential: func(host string) string { return "protocol=http\nhost=" + host + "\n\n" }},
+		{name: "no host", credential: func(host string) string { return "protocol=https\n\n" }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := newTestApi(t, map[string]string{"contents": "read"})
+			host := strings.TrimPrefix(api.server.URL, "http://")
+
+			stdout, stderr, code := runMainWithInput(t, test.credential(host), "-app", "12345", "-pem", keyPath, "-api-url", api.server.URL, "-installation-id", "1", "-output", "git-credential", "get")
+
+			if code != 0 {
+				t.Fatalf("exit code = %d, want 0: %s", code, stderr)
+			}
+			if !test.want {
+				if stdout != "" {
+					t.Errorf("stdout = %q, want empty", stdout)
+				}
+				if requests := api.requests(); len(requests) != 0 {
+					t.Errorf("token is requested: %v", requests)
+				}
+				return
+			}
+
+			want := "protocol=https\nhost=" + host + "\nusername=x-access-token\npassword=" + testToken(1) + "\n"
+			if stdout != want {
+				t.Errorf("stdout = %q, want %q", stdout, want)
+			}
+		})
+	}
+}
+
+func TestPrintJwtClaims(t *testing.T) {
+	keyPath := writeTestKey(t)
+
+	tests := []struct {
+		format string
+		claims func(t *testing.T, line string) string
+	}{
+		{format: "text", claims: func(t *testing.T, line string) string {
+			if !strings.HasPrefix(line, "jwt claims claims=") {
+				t.Fatalf("log = %q, want jwt claims", line)
+			}
+			return strings.TrimPrefix(line, "jwt claims claims=")
+		}},
+		{format: "json", claims: func(t *testing.T, line string) string {
+			log := map[string]json.RawMessage{}
+			if err := json.Unmarshal([]byte(line), &log); err != nil {
+				t.Fatalf("log is not json: %q", line)
+			}
+			if string(log["msg"]) != `"jwt claims"` {
+				t.Fatalf("log = %q, want jwt claims", line)
+			}
+			return string(log["claims"])
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			api := newTestApi(t, map[string]string{"contents": "read"})
+
+			stdout, stderr, code := runMain(t, "-app", "12345", "-pem", keyPath, "-api-url", api.server.URL, "-installation-id", "1", "-print-jwt-claims", "-quiet", "-log-format", test.format)
+
+			if code != 0 {
+				t.Fatalf("exit code = %d, want 0: %s", code, stderr)
+			}
+			if strings.TrimSpace(stdout) != testToken(1) {
+				t.Errorf("stdout = %q, want %s", stdout, testToken(1))
+			}
+
+			claims := map[string]interface{}{}
+			if err := json.Unmarshal([]byte(test.claims(t, strings.TrimSpace(stderr))), &claims); err != nil {
+				t.Fatalf("claims are not a json object: %q", stderr)
+			}
+			if claims["iss"] != "12345" || claims["iat"] == nil || claims["exp"] == nil {
+				t.Errorf("claims = %v, want iss, iat and exp", claims)
+			}
+			if strings.Contains(stderr, "eyJ") {
+				t.Errorf("log contains the jwt: %q", stderr)
+			}
+		})
+	}
+}
+
+func TestJsonFullFromCache(t *testing.T) {
+	api := newTestApi(t, map[string]string{"contents": "read"})
+	arguments := []string{"-app", "12345", "-pem", writeTestKey(t), "-api-url", api.server.URL, "-installation-id", "1", "-output", "json-full", "-cache-file", filepath.Join(t.TempDir(), "cache.json"), "-refresh-margin", "1m"}
+
+	// 2回目はキャッシュしたトークンを使用し、JWTだけを新たに署名する
+	for i := 0; i < 2; i++ {
+		stdout, stderr, code := runMain(t, arguments...)
+		if code != 0 {
+			t.Fatalf("exit code = %d, want 0: %s", code, stderr)
+		}
+
+		output := struct {
+			Jwt           string `json:"jwt"`
+			JwtExpiresAt  string `json:"jwt_expires_at"`
+			JwtTtlSeconds int64  `json:"jwt_ttl_seconds"`
+			Token         string `json:"token"`
+		}{}
+		if err := json.Unmarshal([]byte(stdout), &output); err != nil {
+			t.Fatalf("output is not json: %q", stdout)
+		}
+		if output.Token != testToken(1) || strings.Count(output.Jwt, ".") != 2 {
+			t.Errorf("output = %+v, want the jwt and %s", output, testToken(1))
+		}
+		if expiresAt, err := time.Parse(time.RFC3339, output.JwtExpiresAt); err != nil || !expiresAt.After(time.Now()) || output.JwtTtlSeconds <= 0 {
+			t.Errorf("output = %+v, want the expiry of the jwt", output)
+		}
+	}
+
+	if requests := api.requests(); len(requests) != 1 {
+		t.Errorf("token is requested %d times, want 1", len(requests))
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/zerosspec-dev/github-app-token/token"
+)
+
+// getNetrcHostは.netrcのmachineに書き出すAPIのホスト名を返します。
+func getNetrcHost(apiUrl string) string {
+	if apiUrl == "" {
+		apiUrl = token.DefaultApiUrl
+	}
+
+	parsed, err := url.Parse(apiUrl)
+	if err != nil || parsed.Hostname() == "" {
+		return "api.github.com"
+	}
+	return parsed.Hostname()
+}
+
+// formatNetrcEntryはアクセストークンを認証情報とする.netrcのmachineエントリを返します。
+func formatNetrcEntry(host string, value string) string {
+	return fmt.Sprintf("machine %s login x-access-token password %s\n", host, value)
+}
+
+// isNetrcEntryStartはlineが.netrcの新しいエントリの始まりかどうかを返します。
+func isNetrcEntryStart(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+	switch fields[0] {
+	case "machine", "default", "macdef":
+		return true
+	}
+	return false
+}
+
+// mergeNetrcは既存の.netrcの内容からhostのエントリを取り除き、entryを加えた内容を返します。
+// hostのエントリがあった場合は最初のエントリの位置に置き換え、無い場合は末尾に加えます。
+// エントリは行の先頭のmachine、defaultまたはmacdefから次のエントリの前までとして扱います。
+// macdefのマクロの本体は空行までで、machineなどを含んでいてもエントリとして扱わずそのまま残します。
+func mergeNetrc(existing string, host string, entry string) string {
+	var builder strings.Builder
+	replaced := false
+	skipping := false
+	inMacro := false
+
+	for _, line := range strings.SplitAfter(existing, "\n") {
+		if inMacro {
+			builder.WriteString(line)
+			inMacro = strings.TrimRight(line, "\r\n") != ""
+			continue
+		}
+
+		if isNetrcEntryStart(line) {
+			fields := strings.Fields(line)
+			skipping = fields[0] == "machine" && len(fields) > 1 && fields[1] == host
+			if skipping && !replaced {
+				builder.WriteString(entry)
+				replaced = true
+			}
+			inMacro = fields[0] == "macdef"
+		}
+		if !skipping {
+			builder.WriteString(line)
+		}
+	}
+
+	if !replaced {
+		if builder.Len() > 0 && !strings.HasSuffix(builder.String(), "\n") {
+			builder.WriteString("\n")
+		}
+		builder.WriteString(entry)
+	}
+
+	return builder.String()
+}
+
+// writeNetrcFileはopts.outputFileの.netrcにトークンのエントリを書き出します。
+// 同じホストのエントリが既にある場合は重複させずに置き換えます。
+func writeNetrcFile(args *token.AccessToken, opts *options, result *token.Token) error {
+	existing, err := ioutil.ReadFile(opts.outputFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	host := getNetrcHost(args.ApiUrl)
+	merged := mergeNetrc(string(existing), host, formatNetrcEntry(host, result.Token))
+	return writeFileAtomic(opts.outputFile, []byte(merged))
+}
@@ -0,0 +1,185 @@
+package token
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultConcurrencyはConcurrencyが0の場合に同時にトークンを取得する数です。
+const DefaultConcurrency = 5
+
+// InstallationTokenはAppのインストールごとに取得したインストールアクセストークンです。
+type InstallationToken struct {
+	InstallationId int    `json:"installation_id"`
+	Account        string `json:"account"`
+	Token          string `json:"token"`
+	ExpiresAt      string `json:"expires_at"`
+}
+
+// ExpiresAtTimeはExpiresAtを解析した有効期限の時刻を返します。
+func (token *InstallationToken) ExpiresAtTime() (time.Time, error) {
+	expiresAt, err := time.Parse(time.RFC3339, token.ExpiresAt)
+	if err != nil {
+		return time.Time{}, wrapError(KindNetwork, fmt.Errorf("malformed expires_at %q: %w", token.ExpiresAt, err))
+	}
+	return expiresAt, nil
+}
+
+// InstallationErrorは1つのインストールでトークンを取得できなかったことを示します。
+type InstallationError struct {
+	InstallationId int
+	Account        string
+	Err            error
+}
+
+func (err *InstallationError) Error() string {
+	return fmt.Sprintf("installation %d (%s): %s", err.InstallationId, err.Account, err.Err)
+}
+
+func (err *InstallationError) Unwrap() error {
+	return err.Err
+}
+
+// AllInstallationsErrorはGetAllInstallationsで一部のインストールのトークンを取得できなかったことを示します。
+type AllInstallationsError struct {
+	// Totalはトークンの取得を試みたインストールの数です。
+	Total int
+	// Failuresは失敗したインストールで、インストールの一覧と同じ順に並びます。
+	Failures []InstallationError
+}
+
+func (err *AllInstallationsError) Error() string {
+	messages := make([]string, 0, len(err.Failures))
+	for i := range err.Failures {
+		messages = append(messages, err.Failures[i].Error())
+	}
+	return fmt.Sprintf("failed to get tokens for %d of %d installations: %s", len(err.Failures), err.Total, strings.Join(messages, "; "))
+}
+
+// GetAllInstallationsはAppのすべてのインストールのアクセストークンを取得して返します。
+func (args *AccessToken) GetAllInstallations() ([]InstallationToken, error) {
+	return args.GetAllInstallationsContext(context.Background())
+}
+
+// GetAllInstallationsContextはctxを使用してAppのすべてのインストールのアクセストークンを取得して返します。
+// インストールの一覧はJWTで認証して取得し、Concurrencyの数まで並行してトークンを取得します。
+// 結果はインストールの一覧と同じ順に並べます。一部のインストールで失敗しても残りの取得は続け、
+// 取得できたトークンとともに失敗したインストールをまとめたAllInstallationsErrorを返します。
+// インストールごとにリポジトリが異なるため、RepositoriesとRepositoryIdsは指定できません。
+func (args *AccessToken) GetAllInstallationsContext(ctx context.Context) ([]InstallationToken, error) {
+	if len(args.Repositories) > 0 || len(args.RepositoryIds) > 0 {
+		return nil, wrapError(KindInvalidArgument, fmt.Errorf("repositories cannot be used for all installations"))
+	}
+
+	request, err := args.getAccessTokenRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	err = args.validateTokenUrl()
+	if err != nil {
+		return nil, err
+	}
+
+	apiUrl, err := args.getApiUrl()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := args.getSigningKey()
+	if err != nil {
+		return nil, err
+	}
+
+	// インストールの一覧と各インストールのトークンの取得で接続を共有する
+	client, err := args.newHttpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	installations, err := args.listAppInstallations(ctx, client, key, apiUrl+"/app/installations"+args.getPerPageQuery())
+	if err != nil {
+		return nil, err
+	}
+
+	concurrency := DefaultConcurrency
+	if args.Concurrency > 0 {
+		concurrency = args.Concurrency
+	}
+
+	// 同時に接続する数を抑えるため、インストールの数によらずconcurrencyの数のワーカーで取得する
+	results := make([]*InstallationToken, len(installations))
+	errs := make([]error, len(installations))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i], errs[i] = args.getInstallationToken(ctx, client, key, &installations[i], request)
+			}
+		}()
+	}
+
+	for i := range installations {
+		if ctx.Err() != nil {
+			break
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return nil, wrapError(KindNetwork, ctx.Err())
+	}
+
+	// 1件の失敗で他のインストールの取得は中断せず、失敗したものをまとめて返す
+	tokens := []InstallationToken{}
+	failures := []InstallationError{}
+	for i, installation := range installations {
+		if errs[i] != nil {
+			failures = append(failures, InstallationError{InstallationId: installation.Id, Account: installation.Account, Err: errs[i]})
+			continue
+		}
+		tokens = append(tokens, *results[i])
+	}
+
+	if len(failures) > 0 {
+		return tokens, wrapError(KindOf(failures[0].Err), &AllInstallationsError{Total: len(installations), Failures: failures})
+	}
+
+	return tokens, nil
+}
+
+// getInstallationTokenはinstallationのアクセストークンを取得します。
+// インストールが多い場合にJWTの有効期限が切れないよう、インストールごとに署名します。
+func (args *AccessToken) getInstallationToken(ctx context.Context, client *http.Client, key *signingKey, installation *Installation, request *accessTokenApiRequest) (*InstallationToken, error) {
+	authorization, err := args.getAuthorization(key)
+	if err != nil {
+		return nil, err
+	}
+
+	accessTokensUrl, err := args.getAccessTokensUrl(installation.Id, &installation.accessTokensUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := args.getAccessToken(ctx, client, authorization, accessTokensUrl, request)
+	if err != nil {
+		return nil, err
+	}
+
+	return &InstallationToken{
+		InstallationId: installation.Id,
+		Account:        installation.Account,
+		Token:          response.Token,
+		ExpiresAt:      response.ExpiresAt,
+	}, nil
+}
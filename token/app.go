@@ -0,0 +1,107 @@
+package token
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// AppはGitHub App自身の情報です。
+type App struct {
+	Id                 int    `json:"id"`
+	Slug               string `json:"slug"`
+	Name               string `json:"name"`
+	ClientId           string `json:"client_id"`
+	InstallationsCount int    `json:"installations_count"`
+	// OwnerはAppを所有するユーザーまたはorgです。
+	Owner struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+}
+
+// getAppはauthorizationのJWTで認証してGitHub App自身の情報を取得します。
+func (args *AccessToken) getApp(ctx context.Context, client *http.Client, authorization authorizer) (*App, error) {
+	apiUrl, err := args.getApiUrl()
+	if err != nil {
+		return nil, err
+	}
+
+	app := App{}
+	appApiUrl := apiUrl + "/app"
+	err = args.send(ctx, client, authorization, "GET", &appApiUrl, nil, &app)
+	if err != nil {
+		return nil, err
+	}
+
+	return &app, nil
+}
+
+// matchesAppは取得したAppが指定されたAppIDまたはClient IDと一致するかどうかを返します。
+// AppIdには数値のAppIDの代わりにClient IDやslugも指定できます。
+func (args *AccessToken) matchesApp(app *App) bool {
+	if args.ClientId != "" && args.ClientId != app.ClientId {
+		return false
+	}
+	if args.AppId != "" && args.AppId != strconv.Itoa(app.Id) && args.AppId != app.ClientId && args.AppId != app.Slug {
+		return false
+	}
+	return true
+}
+
+// validateAppは秘密鍵が指定されたAppのものであることを確認し、Appの情報を返します。
+// 別のAppの鍵を使用した場合はインストールの参照で分かりにくい401になるため、先に確認します。
+func (args *AccessToken) validateApp(ctx context.Context, client *http.Client, authorization authorizer) (*App, error) {
+	issuer, err := args.getIssuer()
+	if err != nil {
+		return nil, err
+	}
+
+	app, err := args.getApp(ctx, client, authorization)
+
+	// 署名を検証できない場合は401が返される
+	if isUnauthorized(err) {
+		return nil, wrapError(KindAuth, fmt.Errorf("private key does not belong to app %s: %w", issuer, err))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !args.matchesApp(app) {
+		return nil, wrapError(KindAuth, fmt.Errorf("private key does not belong to app %s: it belongs to app %d (%s)", issuer, app.Id, app.Slug))
+	}
+
+	return app, nil
+}
+
+// logAppは使用しているAppのslugと所有者をデバッグログに出力します。
+func (args *AccessToken) logApp(app *App) {
+	args.debug("using app", Field{"id", app.Id}, Field{"slug", app.Slug}, Field{"owner", app.Owner.Login})
+}
+
+// GetAppはJWTで認証してGitHub App自身の情報を返します。
+func (args *AccessToken) GetApp() (*App, error) {
+	return args.GetAppContext(context.Background())
+}
+
+// GetAppContextはctxを使用し、JWTで認証してGitHub App自身の情報を返します。
+// 秘密鍵がAppIdまたはClientIdのAppのものでない場合はエラーを返します。
+// インストールアクセストークンは取得しないため、鍵とAppの設定の確認に使用できます。
+func (args *AccessToken) GetAppContext(ctx context.Context) (*App, error) {
+	key, err := args.getSigningKey()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := args.newHttpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	authorization, err := args.getAuthorization(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return args.validateApp(ctx, client, authorization)
+}
@@ -0,0 +1,66 @@
+package token
+
+import (
+	"time"
+)
+
+// jwtRefreshMarginはJWTを署名し直す有効期限までの残り時間です。
+// JWTの有効期間の半分がこれより短い場合は有効期間の半分を使用します。
+const jwtRefreshMargin = 30 * time.Second
+
+// authorizerはリクエストのAuthorizationヘッダに設定する資格情報です。
+type authorizer interface {
+	// authorizationは"Bearer "に続けて送信する値を返します。
+	authorization() (string, error)
+}
+
+// bearerTokenはインストールアクセストークンなど、そのまま送信する資格情報です。
+type bearerToken string
+
+func (token bearerToken) authorization() (string, error) {
+	return string(token), nil
+}
+
+// jwtAuthorizationはGitHub Appとして認証するためのJWTです。
+// 再送やRetry-Afterの待ち時間で有効期限が近づいた場合は、次のリクエストの前に署名し直します。
+// 1つの操作の中で順に使用するもので、複数のゴルーチンで共有しません。
+type jwtAuthorization struct {
+	args     *AccessToken
+	key      *signingKey
+	backdate time.Duration
+
+	value     string
+	lifetime  time.Duration
+	expiresAt time.Time
+}
+
+// signはJWTを署名し直します。
+func (auth *jwtAuthorization) sign() error {
+	value, lifetime, expiresAt, err := auth.args.signJwt(auth.key, auth.backdate)
+	if err != nil {
+		return err
+	}
+
+	auth.value = value
+	auth.lifetime = lifetime
+	auth.expiresAt = expiresAt
+	return nil
+}
+
+func (auth *jwtAuthorization) authorization() (string, error) {
+	margin := jwtRefreshMargin
+	if auth.lifetime/2 < margin {
+		margin = auth.lifetime / 2
+	}
+
+	if time.Until(auth.expiresAt) < margin {
+		auth.args.debug("jwt is about to expire, signing a new one", Field{"expires_at", auth.expiresAt.UTC().Format(time.RFC3339)})
+
+		err := auth.sign()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return auth.value, nil
+}
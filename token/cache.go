@@ -0,0 +1,283 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// getCacheKeyはトークンの取得条件を表すキャッシュのキーを返します。
+// 権限やリポジトリが異なる要求で同じトークンを再利用しないよう、これらもキーに含めます。
+func (args *AccessToken) getCacheKey() (string, error) {
+	apiUrl, err := args.getApiUrl()
+	if err != nil {
+		return "", err
+	}
+
+	// mapはキーの順に出力されるため、同じ条件からは同じキーが得られる
+	encoded, err := json.Marshal(struct {
+		AppId                    string            `json:"app_id"`
+		ClientId                 string            `json:"client_id"`
+		JwtIssuer                string            `json:"jwt_iss"`
+		ApiUrl                   string            `json:"api_url"`
+		InstallationId           int               `json:"installation_id"`
+		OrganizationName         string            `json:"org"`
+		RepositoryName           string            `json:"repo"`
+		FallbackOrganizationName string            `json:"fallback_org"`
+		AccountName              string            `json:"account"`
+		RepositoryLookupId       int               `json:"repo_lookup_id"`
+		Permissions              map[string]string `json:"permissions"`
+		SingleFile               string            `json:"single_file"`
+		Repositories             []string          `json:"repositories"`
+		RepositoryIds            []int             `json:"repository_ids"`
+	}{
+		AppId:                    args.AppId,
+		ClientId:                 args.ClientId,
+		JwtIssuer:                args.JwtIssuer,
+		ApiUrl:                   apiUrl,
+		InstallationId:           args.InstallationId,
+		OrganizationName:         args.OrganizationName,
+		RepositoryName:           args.RepositoryName,
+		FallbackOrganizationName: args.FallbackOrganizationName,
+		AccountName:              args.AccountName,
+		RepositoryLookupId:       args.RepositoryLookupId,
+		Permissions:              args.Permissions,
+		SingleFile:               args.SingleFile,
+		Repositories:             args.Repositories,
+		RepositoryIds:            args.RepositoryIds,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// cacheLockTimeoutはキャッシュファイルのロックを待つ最大時間です。
+const cacheLockTimeout = 10 * time.Second
+
+// cacheLockStaleはロックファイルを残したまま終了したプロセスのロックとみなすまでの時間です。
+// キャッシュの更新は読み書きのみで数秒もかからないため、十分に長い時間にしています。
+const cacheLockStale = 30 * time.Second
+
+// cacheLockIntervalはロックファイルが既にある場合に作成し直すまでの間隔です。
+const cacheLockInterval = 50 * time.Millisecond
+
+// cacheFileはキャッシュファイルの内容です。
+// 権限やリポジトリの異なる要求で1つのファイルを共有できるよう、キャッシュのキーごとにトークンを保存します。
+type cacheFile struct {
+	Entries map[string]Token `json:"entries"`
+}
+
+// readCacheFileはキャッシュファイルの内容を返します。
+// ファイルが無い場合や以前の形式などで読み込めない場合は空の内容を返します。
+func (args *AccessToken) readCacheFile() *cacheFile {
+	cache := &cacheFile{}
+	data, err := ioutil.ReadFile(args.CacheFile)
+	if err == nil {
+		err = json.Unmarshal(data, cache)
+	}
+	if err != nil || cache.Entries == nil {
+		cache.Entries = map[string]Token{}
+	}
+	return cache
+}
+
+// readCacheはキャッシュファイルから再利用できるトークンを返します。
+// キャッシュが無い、条件が異なる、または有効期限までRefreshMarginを下回る場合はnilを返します。
+// 書き込みは一時ファイルの置き換えで行うため、読み込みではロックしません。
+// キャッシュから取得できる間は書き込まれないため、有効期限を過ぎたエントリがあればここで取り除きます。
+func (args *AccessToken) readCache(key string) *Token {
+	cache := args.readCacheFile()
+	if hasExpiredEntries(cache) {
+		err := args.pruneCache()
+		if err != nil {
+			args.debug("failed to prune cache", Field{"error", err.Error()})
+		}
+	}
+
+	token, ok := cache.Entries[key]
+	if !ok {
+		return nil
+	}
+
+	expiresAt, err := token.ExpiresAtTime()
+	if err != nil || time.Until(expiresAt) <= jitterRefreshMargin(args.getRefreshMargin()) {
+		return nil
+	}
+
+	return &token
+}
+
+// isExpiredEntryはキャッシュのエントリが有効期限を過ぎているかどうかを返します。
+// 有効期限を解析できないエントリも再利用できないため、過ぎているものとして扱います。
+func isExpiredEntry(entry Token) bool {
+	expiresAt, err := entry.ExpiresAtTime()
+	return err != nil || time.Now().After(expiresAt)
+}
+
+// hasExpiredEntriesはcacheに有効期限を過ぎたエントリがあるかどうかを返します。
+func hasExpiredEntries(cache *cacheFile) bool {
+	for _, entry := range cache.Entries {
+		if isExpiredEntry(entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeExpiredEntriesはcacheから有効期限を過ぎたエントリを取り除きます。
+func removeExpiredEntries(cache *cacheFile) {
+	for key, entry := range cache.Entries {
+		if isExpiredEntry(entry) {
+			delete(cache.Entries, key)
+		}
+	}
+}
+
+// newLockIdはロックファイルに書き込む、ロックを作成したプロセスを識別する値を返します。
+func newLockId() (string, error) {
+	random := make([]byte, 8)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-%s", os.Getpid(), hex.EncodeToString(random)), nil
+}
+
+// lockCacheはキャッシュファイルの隣にロックファイルを作成し、ロックを解放する関数を返します。
+// 行列ビルドなどで複数のプロセスが同時に書き込んでも他のプロセスのエントリを失わないよう、
+// 読み込みから書き込みまでをロックします。
+// ロックファイルには作成したプロセスの識別子を書き込み、他のプロセスのロックを誤って取り除かないようにします。
+func (args *AccessToken) lockCache() (func(), error) {
+	path := args.CacheFile + ".lock"
+	deadline := time.Now().Add(cacheLockTimeout)
+
+	id, err := newLockId()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			_, err = file.WriteString(id)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			return func() { removeLock(path, id) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		// 異常終了したプロセスのロックは解放されないため、古いロックは取り除く
+		// 所有者を読んでから古さを確かめ、その間に作成し直された新しいロックを古いものと取り違えないようにする
+		if owner, stale := isStaleLock(path); stale {
+			args.debug("removing stale cache lock", Field{"path", path}, Field{"owner", owner})
+			removeLock(path, owner)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for cache lock %s", path)
+		}
+		time.Sleep(cacheLockInterval)
+	}
+}
+
+// isStaleLockはロックファイルがcacheLockStaleより前に作成されたものかどうかと、その所有者を返します。
+func isStaleLock(path string) (string, bool) {
+	owner, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) <= cacheLockStale {
+		return "", false
+	}
+
+	return string(owner), true
+}
+
+// removeLockはロックファイルがidのプロセスのものである場合に取り除きます。
+// 古いロックを同時に見つけた別のプロセスが既に取り除いて作成し直したロックを消さないよう、直前に確認します。
+func removeLock(path string, id string) {
+	owner, err := ioutil.ReadFile(path)
+	if err != nil || string(owner) != id {
+		return
+	}
+	os.Remove(path)
+}
+
+// writeCacheはトークンをキャッシュファイルのkeyのエントリに保存し、有効期限を過ぎたエントリを取り除きます。
+// トークンを含むため、ファイルは所有者のみ読み書きできる権限にします。
+func (args *AccessToken) writeCache(key string, token *Token) error {
+	return args.updateCache(func(cache *cacheFile) {
+		// レート制限の状態は取得した時点のもので、キャッシュから再利用する際には古くなっている
+		entry := *token
+		entry.RateLimit = nil
+		cache.Entries[key] = entry
+	})
+}
+
+// pruneCacheはキャッシュファイルから有効期限を過ぎたエントリを取り除きます。
+func (args *AccessToken) pruneCache() error {
+	return args.updateCache(func(cache *cacheFile) {})
+}
+
+// updateCacheはロックした上でキャッシュファイルを読み込み、有効期限を過ぎたエントリを取り除いてからupdateで変更して書き込みます。
+func (args *AccessToken) updateCache(update func(cache *cacheFile)) error {
+	unlock, err := args.lockCache()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cache := args.readCacheFile()
+	removeExpiredEntries(cache)
+	update(cache)
+
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+
+	return writeCacheFileAtomic(args.CacheFile, data)
+}
+
+// writeCacheFileAtomicは読み込み中のプロセスが書きかけの内容を読まないよう、
+// 同じディレクトリの一時ファイルに書き込んでから置き換えます。
+func writeCacheFileAtomic(path string, data []byte) error {
+	temp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	// TempFileは0600で作成するが、umaskなどに依存しないよう明示的に設定する
+	err = temp.Chmod(0600)
+	if err == nil {
+		_, err = temp.Write(data)
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
+}
@@ -0,0 +1,279 @@
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultWebUrlはWebUrlが空の場合に使用するGitHubのベースURLです。
+const DefaultWebUrl = "https://github.com"
+
+// defaultDeviceCodeExpiryはデバイスコードの有効期間が返されなかった場合に使用する期間です。
+// GitHubが発行するデバイスコードの有効期間と同じ15分です。
+const defaultDeviceCodeExpiry = 15 * time.Minute
+
+// DeviceCodeはデバイスフローで発行されたコードです。
+// ユーザーはVerificationUriを開いてUserCodeを入力し、Appを認可します。
+type DeviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationUri string `json:"verification_uri"`
+	// ExpiresInはコードの有効期間(秒)です。
+	ExpiresIn int `json:"expires_in"`
+	// Intervalはトークンを問い合わせる間隔(秒)です。
+	Interval int `json:"interval"`
+}
+
+// UserTokenはデバイスフローで取得したユーザーアクセストークンです。
+// トークンの有効期限が無効なAppの場合、ExpiresAtとRefreshTokenは空です。
+type UserToken struct {
+	Token        string `json:"token"`
+	TokenType    string `json:"token_type"`
+	Scope        string `json:"scope"`
+	ExpiresAt    string `json:"expires_at,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+type accessTokenOAuthResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	Scope            string `json:"scope"`
+	ExpiresIn        int    `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+	Interval         int    `json:"interval"`
+}
+
+// getWebUrlは末尾のスラッシュを除いたGitHubのベースURLを返します。
+func (args *AccessToken) getWebUrl() (string, error) {
+	webUrl := args.WebUrl
+	if webUrl == "" {
+		webUrl = DefaultWebUrl
+	}
+
+	parsed, err := url.Parse(webUrl)
+	if err != nil {
+		return "", wrapError(KindInvalidArgument, fmt.Errorf("invalid web url: %w", err))
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", wrapError(KindInvalidArgument, fmt.Errorf("invalid web url: %s", webUrl))
+	}
+
+	return strings.TrimRight(webUrl, "/"), nil
+}
+
+// getDeviceClientIdはデバイスフローで使用するClient IDを返します。
+// デバイスフローは数値のAppIDを受け付けないため、AppIdにはClient IDが指定されている必要があります。
+func (args *AccessToken) getDeviceClientId() (string, error) {
+	if args.ClientId != "" {
+		return args.ClientId, nil
+	}
+	if args.AppId != "" {
+		return args.AppId, nil
+	}
+	return "", wrapError(KindInvalidArgument, fmt.Errorf("client id is not set"))
+}
+
+// postFormはOAuthのエンドポイントにフォームを送信し、結果をtargetにマップします。
+// OAuthのエンドポイントはAPIと異なりjsonのリクエストボディとBearer認証を使用しないため、sendとは別に送信します。
+func (args *AccessToken) postForm(ctx context.Context, client *http.Client, endpoint string, values url.Values, target interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(values.Encode()))
+	if err != nil {
+		return wrapError(KindInvalidArgument, err)
+	}
+
+	userAgent := DefaultUserAgent
+	if args.UserAgent != "" {
+		userAgent = args.UserAgent
+	}
+
+	request.Header = map[string][]string{
+		"Accept":       {"application/json"},
+		"Content-Type": {"application/x-www-form-urlencoded"},
+		"User-Agent":   {userAgent},
+	}
+	args.setCustomHeaders(request.Header)
+
+	args.debug("request", Field{"method", "POST"}, Field{"url", endpoint})
+
+	start := time.Now()
+	response, err := client.Do(request)
+	if err != nil {
+		return wrapError(KindNetwork, wrapTimeoutError(client, err))
+	}
+	defer response.Body.Close()
+
+	args.debug("response", Field{"method", "POST"}, Field{"url", endpoint}, Field{"status", response.StatusCode}, Field{"duration_ms", time.Since(start).Milliseconds()})
+
+	if response.StatusCode/100 != 2 {
+		responseErr := &ResponseError{
+			Method:     "POST",
+			Url:        endpoint,
+			StatusCode: response.StatusCode,
+			Status:     response.Status,
+			RequestId:  response.Header.Get("X-GitHub-Request-Id"),
+		}
+		// sendと同じく、メッセージが送信したコードやトークンを含むことがあるため、エラーに含める前に伏せる
+		if errorBody := args.readErrorBody(response.Body); errorBody != nil {
+			responseErr.Message = redactForm(errorBody.Message, values)
+			responseErr.DocumentationUrl = errorBody.DocumentationUrl
+		}
+		return wrapError(getResponseErrorKind(response), responseErr)
+	}
+
+	maxResponseBytes := int64(DefaultMaxResponseBytes)
+	if args.MaxResponseBytes > 0 {
+		maxResponseBytes = args.MaxResponseBytes
+	}
+
+	responseBody, err := ioutil.ReadAll(io.LimitReader(response.Body, maxResponseBytes+1))
+	if err != nil {
+		return wrapError(KindNetwork, wrapTimeoutError(client, err))
+	}
+	if int64(len(responseBody)) > maxResponseBytes {
+		return wrapError(KindNetwork, fmt.Errorf("response body is too large: exceeds max-response-bytes %d", maxResponseBytes))
+	}
+
+	args.debug("response body", Field{"body", redactBody(responseBody)})
+
+	err = json.Unmarshal(responseBody, target)
+	if err != nil {
+		return wrapError(KindNetwork, fmt.Errorf("malformed response: %w", err))
+	}
+
+	return nil
+}
+
+// RequestDeviceCodeはデバイスフローを開始し、ユーザーに入力してもらうコードを返します。
+func (args *AccessToken) RequestDeviceCode() (*DeviceCode, error) {
+	return args.RequestDeviceCodeContext(context.Background())
+}
+
+// RequestDeviceCodeContextはctxを使用してデバイスフローを開始し、ユーザーに入力してもらうコードを返します。
+func (args *AccessToken) RequestDeviceCodeContext(ctx context.Context) (*DeviceCode, error) {
+	webUrl, err := args.getWebUrl()
+	if err != nil {
+		return nil, err
+	}
+
+	clientId, err := args.getDeviceClientId()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := args.newHttpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	code := DeviceCode{}
+	err = args.postForm(ctx, client, webUrl+"/login/device/code", url.Values{"client_id": {clientId}}, &code)
+	if err != nil {
+		return nil, err
+	}
+
+	// デバイスフローが無効なAppではエラーの内容を200で返すため、コードの有無で確認する
+	if code.DeviceCode == "" {
+		return nil, wrapError(KindAuth, fmt.Errorf("device code was not issued: check that device flow is enabled for the app"))
+	}
+
+	return &code, nil
+}
+
+// PollUserTokenはユーザーがAppを認可するまでトークンを問い合わせ、ユーザーアクセストークンを返します。
+// 問い合わせの間隔はcode.Intervalに従い、code.ExpiresIn(返されなかった場合は15分)を過ぎた場合はエラーを返します。
+func (args *AccessToken) PollUserToken(ctx context.Context, code *DeviceCode) (*UserToken, error) {
+	webUrl, err := args.getWebUrl()
+	if err != nil {
+		return nil, err
+	}
+
+	clientId, err := args.getDeviceClientId()
+	if err != nil {
+		return nil, err
+	}
+
+	// 問い合わせのたびに接続し直さないよう、同じクライアントを使用する
+	client, err := args.newHttpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	values := url.Values{
+		"client_id":   {clientId},
+		"device_code": {code.DeviceCode},
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+	}
+
+	// GitHubの既定の間隔は5秒
+	interval := 5 * time.Second
+	if code.Interval > 0 {
+		interval = time.Duration(code.Interval) * time.Second
+	}
+
+	// 有効期間が返されなかった場合に問い合わせる前に失敗しないよう、GitHubの既定の期間を使用する
+	expiry := defaultDeviceCodeExpiry
+	if code.ExpiresIn > 0 {
+		expiry = time.Duration(code.ExpiresIn) * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, expiry)
+	defer cancel()
+
+	for {
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, wrapError(KindAuth, fmt.Errorf("device code expired before the app was authorized"))
+			}
+			return nil, wrapError(KindNetwork, ctx.Err())
+		case <-timer.C:
+		}
+
+		response := accessTokenOAuthResponse{}
+		err := args.postForm(ctx, client, webUrl+"/login/oauth/access_token", values, &response)
+		if err != nil {
+			return nil, err
+		}
+
+		switch response.Error {
+		case "":
+			token := &UserToken{
+				Token:        response.AccessToken,
+				TokenType:    response.TokenType,
+				Scope:        response.Scope,
+				RefreshToken: response.RefreshToken,
+			}
+			if response.ExpiresIn > 0 {
+				token.ExpiresAt = time.Now().Add(time.Duration(response.ExpiresIn) * time.Second).UTC().Format(time.RFC3339)
+			}
+			return token, nil
+		case "authorization_pending":
+			args.debug("waiting for the user to authorize the app")
+		case "slow_down":
+			// 問い合わせが早すぎる場合は新しい間隔が返される
+			if response.Interval > 0 {
+				interval = time.Duration(response.Interval) * time.Second
+			} else {
+				interval += 5 * time.Second
+			}
+			args.debug("polling too fast, slowing down", Field{"interval", interval.String()})
+		case "expired_token":
+			return nil, wrapError(KindAuth, fmt.Errorf("device code expired before the app was authorized"))
+		case "access_denied":
+			return nil, wrapError(KindAuth, fmt.Errorf("authorization was denied by the user"))
+		default:
+			return nil, wrapError(KindAuth, fmt.Errorf("failed to get user access token: %s: %s", response.Error, redactForm(response.ErrorDescription, values)))
+		}
+	}
+}
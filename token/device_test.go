@@ -0,0 +1,76 @@
+package token
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testDeviceCode = "3584d83530557fdd1f46af8289938c8ef79f9dc5"
+
+// newDeviceTestServerはOAuthのトークンのエンドポイントとして、statusとbodyを返すサーバーを起動します。
+func newDeviceTestServer(t *testing.T, status int, body string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/login/oauth/access_token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestPollUserTokenRedactsError(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{name: "response error", status: http.StatusBadRequest, body: `{"message":"device code ` + testDeviceCode + ` is invalid, token ` + testUserToken + `"}`},
+		{name: "oauth error", status: http.StatusOK, body: `{"error":"incorrect_device_code","error_description":"device code ` + testDeviceCode + ` is invalid, token ` + testUserToken + `"}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newDeviceTestServer(t, test.status, test.body)
+			logger := &recordLogger{}
+			args := &AccessToken{ClientId: "Iv1.0123456789abcdef", WebUrl: server.URL, HttpClient: server.Client(), Logger: logger}
+
+			_, err := args.PollUserToken(context.Background(), &DeviceCode{DeviceCode: testDeviceCode, ExpiresIn: 900, Interval: 1})
+			if err == nil {
+				t.Fatal("PollUserToken() error = nil")
+			}
+			for _, secret := range []string{testDeviceCode, testUserToken} {
+				if strings.Contains(err.Error(), secret) {
+					t.Errorf("error contains secret %s: %s", secret, err.Error())
+				}
+			}
+			if !strings.Contains(err.Error(), "device code *** is invalid") {
+				t.Errorf("error = %q, want the redacted message", err.Error())
+			}
+			assertNoSecrets(t, "log", logger.String())
+		})
+	}
+}
+
+func TestPollUserTokenWithoutExpiresIn(t *testing.T) {
+	server := newDeviceTestServer(t, http.StatusOK, `{"access_token":"`+testUserToken+`","token_type":"bearer","scope":""}`)
+	args := &AccessToken{ClientId: "Iv1.0123456789abcdef", WebUrl: server.URL, HttpClient: server.Client()}
+
+	result, err := args.PollUserToken(context.Background(), &DeviceCode{DeviceCode: testDeviceCode, Interval: 1})
+	if err != nil {
+		t.Fatalf("PollUserToken() error = %v", err)
+	}
+	if result.Token != testUserToken {
+		t.Errorf("PollUserToken() = %+v", result)
+	}
+}
@@ -0,0 +1,146 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrorKindはエラーの分類です。呼び出し側で失敗の原因ごとに処理を分けるために使用します。
+type ErrorKind int
+
+const (
+	// KindUnknownは分類されていないエラーです。
+	KindUnknown ErrorKind = iota
+	// KindInvalidArgumentは引数の誤りです。
+	KindInvalidArgument
+	// KindAuthは秘密鍵の読み込みやJWTの署名、GitHubでの認証の失敗です。
+	KindAuth
+	// KindNetworkは通信の失敗やGitHub APIのエラーです。
+	KindNetwork
+	// KindRateLimitはGitHub APIのレート制限です。
+	KindRateLimit
+)
+
+// 以下はerrors.Isで失敗の原因を判別するためのエラーです。
+// 返されるエラーはこれらを直接ラップせず、ErrorのKindやResponseErrorのステータスコードで該当するかを判定します。
+var (
+	// ErrAuthは秘密鍵の読み込みやJWTの署名、GitHubでの認証の失敗(KindAuth)です。
+	ErrAuth = errors.New("authentication failed")
+	// ErrRateLimitedはGitHub APIのレート制限(KindRateLimit)です。
+	ErrRateLimited = errors.New("rate limited")
+	// ErrNotFoundはGitHub APIが404を返したことを示します。KindNetworkのエラーでもあります。
+	ErrNotFound = errors.New("not found")
+	// ErrNetworkは通信の失敗やGitHub APIのエラー(KindNetwork)です。
+	ErrNetwork = errors.New("network error")
+)
+
+// Errorは分類付きのエラーです。
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (err *Error) Error() string {
+	return err.Err.Error()
+}
+
+func (err *Error) Unwrap() error {
+	return err.Err
+}
+
+// Isはtargetがエラーの分類に対応するエラーかどうかを返します。
+func (err *Error) Is(target error) bool {
+	switch target {
+	case ErrAuth:
+		return err.Kind == KindAuth
+	case ErrRateLimited:
+		return err.Kind == KindRateLimit
+	case ErrNetwork:
+		return err.Kind == KindNetwork
+	}
+	return false
+}
+
+// wrapErrorはerrを分類付きのエラーにします。
+// 既に分類されている場合はより詳細な元の分類を優先します。
+func wrapError(kind ErrorKind, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var typed *Error
+	if errors.As(err, &typed) {
+		return err
+	}
+
+	return &Error{Kind: kind, Err: err}
+}
+
+// KindOfはerrの分類を返します。分類されていない場合はKindUnknownを返します。
+func KindOf(err error) ErrorKind {
+	var typed *Error
+	if errors.As(err, &typed) {
+		return typed.Kind
+	}
+	return KindUnknown
+}
+
+// isUnauthorizedはerrがGitHub APIの401であるかどうかを返します。
+func isUnauthorized(err error) bool {
+	var responseErr *ResponseError
+	return errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusUnauthorized
+}
+
+// isNotFoundはerrがGitHub APIの404によるものかどうかを返します。
+func isNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// isClockSkewErrorはerrがJWTのiatが未来の時刻であるために拒否されたことによるものかどうかを返します。
+// GitHubは401のメッセージでiatの誤りを示すため、メッセージで判別します。
+func isClockSkewError(err error) bool {
+	var responseErr *ResponseError
+	if !errors.As(err, &responseErr) || responseErr.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+
+	message := strings.ToLower(responseErr.Message)
+	return strings.Contains(message, "'iat'") && (strings.Contains(message, "future") || strings.Contains(message, "issued at"))
+}
+
+// ResponseErrorはGitHub APIが2xx以外のステータスを返したことを示します。
+type ResponseError struct {
+	Method     string
+	Url        string
+	StatusCode int
+	Status     string
+	// MessageはGitHubがレスポンスボディで返したエラーメッセージです。
+	// ボディがjsonでない場合は空です。
+	Message string
+	// DocumentationUrlはGitHubがレスポンスボディで返したドキュメントのURLです。
+	DocumentationUrl string
+	// RequestIdはレスポンスのX-GitHub-Request-Idヘッダーの値です。
+	// GitHubのサポートに問い合わせる際に必要になります。
+	RequestId string
+}
+
+// IsはtargetがErrNotFoundで、ステータスコードが404の場合にtrueを返します。
+func (err *ResponseError) Is(target error) bool {
+	return target == ErrNotFound && err.StatusCode == http.StatusNotFound
+}
+
+func (err *ResponseError) Error() string {
+	message := fmt.Sprintf("request failed: %s", err.Status)
+	if err.Message != "" {
+		message += ": " + err.Message
+	}
+	if err.DocumentationUrl != "" {
+		message += " (see " + err.DocumentationUrl + ")"
+	}
+	if err.RequestId != "" {
+		message += " (request id: " + err.RequestId + ")"
+	}
+	return message
+}
@@ -0,0 +1,64 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorsIs(t *testing.T) {
+	sentinels := []error{ErrAuth, ErrRateLimited, ErrNotFound, ErrNetwork}
+
+	tests := []struct {
+		name    string
+		status  int
+		headers map[string]string
+		want    []error
+	}{
+		{name: "unauthorized", status: http.StatusUnauthorized, want: []error{ErrAuth}},
+		{name: "rate limited", status: http.StatusForbidden, headers: map[string]string{"X-RateLimit-Remaining": "0"}, want: []error{ErrRateLimited}},
+		{name: "retry after", status: http.StatusForbidden, headers: map[string]string{"Retry-After": "60"}, want: []error{ErrRateLimited}},
+		{name: "forbidden", status: http.StatusForbidden, want: []error{ErrNetwork}},
+		{name: "not found", status: http.StatusNotFound, want: []error{ErrNotFound, ErrNetwork}},
+		{name: "dial failure", want: []error{ErrNetwork}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for name, value := range test.headers {
+					w.Header().Set(name, value)
+				}
+				w.WriteHeader(test.status)
+				fmt.Fprint(w, `{"message":"error"}`)
+			}))
+			args := newTestAccessToken(t, server)
+			args.MaxRetries = 0
+
+			// 接続できないよう、サーバーを止めてから同じアドレスに送信する
+			if test.status == 0 {
+				server.Close()
+			} else {
+				defer server.Close()
+			}
+
+			_, err := args.Get()
+			if err == nil {
+				t.Fatal("Get() error = nil")
+			}
+			wrapped := fmt.Errorf("failed to get token: %w", err)
+
+			for _, sentinel := range sentinels {
+				want := false
+				for _, target := range test.want {
+					want = want || target == sentinel
+				}
+				if got := errors.Is(wrapped, sentinel); got != want {
+					t.Errorf("errors.Is(%v, %v) = %v, want %v", wrapped, sentinel, got, want)
+				}
+			}
+		})
+	}
+}
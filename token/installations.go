@@ -0,0 +1,205 @@
+package token
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// MaxPerPageはGitHub APIの一覧取得で1ページに指定できる件数の上限です。
+const MaxPerPage = 100
+
+// Installationはインストールの一覧の1件です。
+type Installation struct {
+	Id      int    `json:"id"`
+	Account string `json:"account"`
+	AppSlug string `json:"app_slug,omitempty"`
+
+	// accessTokensUrlはインストールアクセストークンを取得するAPIのURLです。
+	// Appのインストールの一覧にのみ含まれます。
+	accessTokensUrl string
+}
+
+type installationsApiItem struct {
+	Id      int `json:"id"`
+	Account struct {
+		Login string `json:"login"`
+	} `json:"account"`
+	AppSlug         string `json:"app_slug"`
+	AccessTokensUrl string `json:"access_tokens_url"`
+}
+
+type orgInstallationsApiResponse struct {
+	Installations []installationsApiItem `json:"installations"`
+}
+
+// getPerPageQueryは一覧取得のURLに付加するper_pageのクエリを返します。
+// PerPageがMaxPerPageを超える場合はMaxPerPageにします。
+func (args *AccessToken) getPerPageQuery() string {
+	perPage := args.PerPage
+	if perPage <= 0 {
+		return ""
+	}
+	if perPage > MaxPerPage {
+		perPage = MaxPerPage
+	}
+	return fmt.Sprintf("?per_page=%d", perPage)
+}
+
+// getNextLinkはLinkヘッダからrel="next"のURLを返します。次のページが無い場合は空文字を返します。
+func getNextLink(header http.Header) string {
+	for _, link := range strings.Split(header.Get("Link"), ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 {
+			continue
+		}
+
+		for _, param := range parts[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(parts[0]), "<>")
+			}
+		}
+	}
+
+	return ""
+}
+
+// toInstallationsはAPIのレスポンスをインストールの一覧に変換します。
+func toInstallations(items []installationsApiItem) []Installation {
+	installations := make([]Installation, 0, len(items))
+	for _, item := range items {
+		installations = append(installations, Installation{
+			Id:      item.Id,
+			Account: item.Account.Login,
+			AppSlug: item.AppSlug,
+
+			accessTokensUrl: item.AccessTokensUrl,
+		})
+	}
+	return installations
+}
+
+// ListInstallationsはインストールの一覧を返します。
+func (args *AccessToken) ListInstallations() ([]Installation, error) {
+	return args.ListInstallationsContext(context.Background())
+}
+
+// ListInstallationsContextはctxを使用してインストールの一覧を返します。
+// OrganizationNameが空の場合はJWTで認証してAppのインストールの一覧を返します。
+// OrganizationNameが指定されている場合はorgのインストールアクセストークンで認証し、
+// orgにインストールされているAppの一覧を返します。トークンにはorganization_administrationのread権限が必要です。
+// 結果が複数ページに分かれている場合はLinkヘッダのrel="next"をたどってすべて取得します。
+func (args *AccessToken) ListInstallationsContext(ctx context.Context) ([]Installation, error) {
+	apiUrl, err := args.getApiUrl()
+	if err != nil {
+		return nil, err
+	}
+
+	if args.OrganizationName == "" {
+		key, err := args.getSigningKey()
+		if err != nil {
+			return nil, err
+		}
+
+		client, err := args.newHttpClient()
+		if err != nil {
+			return nil, err
+		}
+
+		return args.listAppInstallations(ctx, client, key, apiUrl+"/app/installations"+args.getPerPageQuery())
+	}
+
+	// トークンの取得と一覧の取得で同じクライアントを使用し、接続と設定を共有する
+	client, err := args.newHttpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := args.getContext(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	listApiUrl := fmt.Sprintf("%s/orgs/%s/installations%s", apiUrl, args.OrganizationName, args.getPerPageQuery())
+	return args.listOrgInstallations(ctx, client, token.Token, listApiUrl)
+}
+
+// listAppInstallationsはJWTで認証してlistApiUrlから順にすべてのページを取得します。
+func (args *AccessToken) listAppInstallations(ctx context.Context, client *http.Client, key *signingKey, listApiUrl string) ([]Installation, error) {
+	installations := []Installation{}
+	for listApiUrl != "" {
+		// ページ数が多い場合にJWTの有効期限が切れないようページごとに署名する
+		authorization, err := args.getAuthorization(key)
+		if err != nil {
+			return nil, err
+		}
+
+		items := []installationsApiItem{}
+		meta, err := args.sendWithMeta(ctx, client, authorization, "GET", &listApiUrl, nil, &items)
+		if err != nil {
+			return nil, err
+		}
+
+		installations = append(installations, toInstallations(items)...)
+		listApiUrl = meta.NextLink
+	}
+
+	return installations, nil
+}
+
+// listOrgInstallationsはインストールアクセストークンで認証してlistApiUrlから順にすべてのページを取得します。
+func (args *AccessToken) listOrgInstallations(ctx context.Context, client *http.Client, token string, listApiUrl string) ([]Installation, error) {
+	installations := []Installation{}
+	for listApiUrl != "" {
+		response := orgInstallationsApiResponse{}
+		meta, err := args.sendWithMeta(ctx, client, bearerToken(token), "GET", &listApiUrl, nil, &response)
+		if err != nil {
+			return nil, err
+		}
+
+		installations = append(installations, toInstallations(response.Installations)...)
+		listApiUrl = meta.NextLink
+	}
+
+	return installations, nil
+}
+
+// findOwnerInstallationはAppのインストールの一覧からownerにインストールされたものを探します。
+// orgのインストールの参照はownerがユーザーの場合などに404になるため、その場合の代わりとして使用します。
+// 見つからない場合はnilを返し、複数見つかった場合は候補のインストールIDを含むエラーを返します。
+// ページごとに署名し直さずauthorizationのJWTを使用し、有効期限が近づいた場合のみ署名し直します。
+func (args *AccessToken) findOwnerInstallation(ctx context.Context, client *http.Client, authorization authorizer, apiUrl string, owner string) (*installationApiResponse, error) {
+	candidates := []installationsApiItem{}
+	listApiUrl := apiUrl + "/app/installations" + args.getPerPageQuery()
+	for listApiUrl != "" {
+		items := []installationsApiItem{}
+		meta, err := args.sendWithMeta(ctx, client, authorization, "GET", &listApiUrl, nil, &items)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range items {
+			if strings.EqualFold(item.Account.Login, owner) {
+				candidates = append(candidates, item)
+			}
+		}
+		listApiUrl = meta.NextLink
+	}
+
+	switch len(candidates) {
+	case 0:
+		return nil, nil
+	case 1:
+		args.debug("using the only installation of the owner", Field{"owner", owner}, Field{"installation_id", candidates[0].Id})
+		accessTokensUrl := candidates[0].AccessTokensUrl
+		return &installationApiResponse{Id: candidates[0].Id, AccessTokensUrl: &accessTokensUrl}, nil
+	}
+
+	ids := []string{}
+	for _, candidate := range candidates {
+		ids = append(ids, strconv.Itoa(candidate.Id))
+	}
+	return nil, wrapError(KindInvalidArgument, fmt.Errorf("multiple installations found for %s: %s: set installation-id", owner, strings.Join(ids, ", ")))
+}
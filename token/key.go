@@ -0,0 +1,328 @@
+package token
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/youmark/pkcs8"
+)
+
+// ErrPassphraseRequiredは暗号化された秘密鍵に対してパスフレーズが指定されていない場合のエラーです。
+var ErrPassphraseRequired = errors.New("private key is encrypted but no passphrase is given")
+
+// readPrivateKeyDataはファイル、標準入力またはPrivateKeyからPEMの内容を読み出して返します。
+// 両方が指定されている場合はファイルを優先します。
+func (args *AccessToken) readPrivateKeyData() ([]byte, error) {
+	if args.PemFilePath != "" {
+		return readPrivateKeyFile(args.PemFilePath)
+	}
+
+	if len(args.PrivateKey) == 0 {
+		if args.PrivateKeyBase64 != "" {
+			return decodePrivateKeyBase64(args.PrivateKeyBase64)
+		}
+		return nil, fmt.Errorf("private key is not set")
+	}
+
+	// 1行で登録されたシークレットの"\n"を改行に戻す
+	// 文字列に変換すると消去できない複製が残るため、バイト列のまま置き換える
+	return bytes.ReplaceAll(args.PrivateKey, []byte(`\n`), []byte("\n")), nil
+}
+
+// readPrivateKeyFileはpathから秘密鍵のPEMを読み込みます。"-"の場合は標準入力から読み込みます。
+// /dev/fd/3や名前付きパイプのようにシークできないファイルも読めるよう、開いたファイルを先頭から順に読み込みます。
+func readPrivateKeyFile(path string) ([]byte, error) {
+	name, reader := "stdin", os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		name, reader = path, file
+	}
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key from %s: %w", name, err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty key: %s contains no data", name)
+	}
+
+	return data, nil
+}
+
+// base64Encodingsはbase64でエンコードされた秘密鍵を復号する際に順に試す形式です。
+var base64Encodings = []*base64.Encoding{
+	base64.StdEncoding,
+	base64.RawStdEncoding,
+	base64.URLEncoding,
+	base64.RawURLEncoding,
+}
+
+// decodePrivateKeyBase64はbase64でエンコードされたPEMを復号します。
+// シークレットの保存先によって形式が異なるため、PEMとして読み込める形式が見つかるまで順に試します。
+func decodePrivateKeyBase64(value string) ([]byte, error) {
+	value = strings.Join(strings.Fields(value), "")
+
+	for _, encoding := range base64Encodings {
+		decoded, err := encoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+		if block, _ := pem.Decode(decoded); block != nil {
+			return decoded, nil
+		}
+		zero(decoded)
+	}
+
+	return nil, fmt.Errorf("base64 private key does not decode to a valid PEM block")
+}
+
+// getSigningKeyはJWTの署名に使用する鍵と署名方式を返します。
+// 複数の鍵が指定されている場合は最初の鍵を返します。
+func (args *AccessToken) getSigningKey() (*signingKey, error) {
+	keys, err := args.getSigningKeys()
+	if err != nil {
+		return nil, err
+	}
+	return keys[0], nil
+}
+
+// LoadKeysは秘密鍵を読み込んで保持し、以降の呼び出しでは読み込み直さずに使用します。
+// 標準入力や/dev/fd/3のように1回しか読めない場所から鍵を読み込み、トークンを繰り返し取得する場合に使用します。
+// 読み込んだ後にPemFilePathやPrivateKeyなどを変更しても反映されません。
+// トークンの取得と並行して呼び出すことはできません。
+func (args *AccessToken) LoadKeys() error {
+	keys, err := args.readSigningKeys()
+	if err != nil {
+		return err
+	}
+
+	args.keys = keys
+	return nil
+}
+
+// getSigningKeysはJWTの署名に使用できる鍵と署名方式を指定された順に返します。
+// LoadKeysで読み込み済みの場合はその鍵を返します。
+func (args *AccessToken) getSigningKeys() ([]*signingKey, error) {
+	if args.keys != nil {
+		return args.keys, nil
+	}
+	return args.readSigningKeys()
+}
+
+// readSigningKeysはJWTの署名に使用できる鍵と署名方式を指定された順に返します。
+// Signerが指定されていない場合はファイルまたはPrivateKeyから秘密鍵を読み出します。
+func (args *AccessToken) readSigningKeys() ([]*signingKey, error) {
+	if args.Signer != nil {
+		method, err := getSigningMethod(args.Signer)
+		if err != nil {
+			return nil, wrapError(KindAuth, err)
+		}
+		return []*signingKey{{key: args.Signer, method: method}}, nil
+	}
+
+	// 鍵を読み込めない場合はJWTを署名できないため認証の失敗として扱う
+	keys, err := args.readPrivateKeys()
+	if err != nil {
+		return nil, wrapError(KindAuth, err)
+	}
+	return keys, nil
+}
+
+// readPrivateKeysはファイルまたはPrivateKeyと、FallbackPemFilePathsから秘密鍵を読み出し、
+// 鍵の種類に対応する署名方式とともに返します。1つのファイルに複数の鍵が含まれていても構いません。
+func (args *AccessToken) readPrivateKeys() ([]*signingKey, error) {
+	secret, err := args.readPrivateKeyData()
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := [][]byte{secret}
+	for _, path := range args.FallbackPemFilePaths {
+		secret, err := readPrivateKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+
+	keys := []*signingKey{}
+	for _, secret := range secrets {
+		privateKeys, err := args.readPrivateKey(secret)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, privateKeys...)
+	}
+
+	return keys, nil
+}
+
+// privateKeyBlockTypesは秘密鍵として読み込むPEMのブロックの種類です。
+var privateKeyBlockTypes = map[string]bool{
+	"RSA PRIVATE KEY":       true,
+	"PRIVATE KEY":           true,
+	"ENCRYPTED PRIVATE KEY": true,
+}
+
+// readPrivateKeyはPEMに含まれる秘密鍵を読み出し、鍵の種類に対応する署名方式とともに返します。
+// 証明書など秘密鍵以外のブロックは読み飛ばし、秘密鍵のブロックが1つも無い場合はエラーにします。
+// 読み込んだPEMは解析後に不要になるため、メモリ上に残る時間を短くするよう消去します。
+// PEMは常に読み込み時に作成した新しいスライスのため、PrivateKeyの値は変更されません。
+func (args *AccessToken) readPrivateKey(secret []byte) ([]*signingKey, error) {
+	defer zero(secret)
+
+	keys := []*signingKey{}
+	skipped := []string{}
+	for rest := secret; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		// 同じファイルにまとめられた証明書などは読み飛ばす
+		if !privateKeyBlockTypes[block.Type] {
+			args.debug("skipping PEM block that is not a private key", Field{"type", block.Type})
+			skipped = append(skipped, block.Type)
+			continue
+		}
+
+		key, err := args.parsePrivateKey(block)
+		if err != nil {
+			return nil, err
+		}
+
+		method, err := getSigningMethod(key)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &signingKey{key: key, method: method})
+	}
+
+	// 誤って公開鍵や証明書、別のファイルを指定した場合によく起こるため、その旨が分かるメッセージにする
+	if len(keys) == 0 && len(skipped) > 0 {
+		return nil, fmt.Errorf("file does not contain a valid PEM private key block, only %s: expected RSA PRIVATE KEY, PRIVATE KEY or ENCRYPTED PRIVATE KEY", strings.Join(skipped, ", "))
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("file does not contain a valid PEM block")
+	}
+
+	return keys, nil
+}
+
+// parsePrivateKeyはPEMのブロックから秘密鍵を取り出して返します。
+func (args *AccessToken) parsePrivateKey(block *pem.Block) (crypto.PrivateKey, error) {
+	defer zero(block.Bytes)
+
+	passphrase := args.Passphrase
+
+	switch {
+	case block.Type == "ENCRYPTED PRIVATE KEY":
+		// 暗号化されたPKCS#8形式
+		if passphrase == "" {
+			return nil, ErrPassphraseRequired
+		}
+
+		password := []byte(passphrase)
+		defer zero(password)
+
+		key, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt private key: %w", err)
+		}
+
+		return key, nil
+	case x509.IsEncryptedPEMBlock(block):
+		// Proc-Type: 4,ENCRYPTEDヘッダを持つ形式
+		if passphrase == "" {
+			return nil, ErrPassphraseRequired
+		}
+
+		password := []byte(passphrase)
+		defer zero(password)
+
+		der, err := x509.DecryptPEMBlock(block, password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt private key: %w", err)
+		}
+
+		return parsePrivateKeyDer(block.Type, der)
+	case passphrase != "":
+		args.warn("passphrase is set but the private key is not encrypted")
+	}
+
+	// block.Bytesはこの関数の終了時に消去するため、複製せずに渡す
+	return parsePrivateKeyDer(block.Type, block.Bytes)
+}
+
+// parsePrivateKeyDerはDER形式の秘密鍵を解析して返します。
+// derは復号した鍵そのものであることがあるため、解析後に消去します。
+func parsePrivateKeyDer(blockType string, der []byte) (crypto.PrivateKey, error) {
+	defer zero(der)
+
+	// PKCS#8形式("BEGIN PRIVATE KEY")はRSA以外の鍵も含み得るため、署名方式の選択で確認する
+	if blockType == "PRIVATE KEY" {
+		return x509.ParsePKCS8PrivateKey(der)
+	}
+
+	privatekey, err := x509.ParsePKCS1PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return privatekey, nil
+}
+
+// zeroはスライスの内容を0で上書きします。
+func zero(data []byte) {
+	for i := range data {
+		data[i] = 0
+	}
+}
+
+// getSigningMethodは鍵の種類に対応するJWTの署名方式を返します。
+// GitHubが受け付けるのはRS256のみのため、現在はRSA鍵以外をエラーにします。
+func getSigningMethod(key crypto.PrivateKey) (jwt.SigningMethod, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type: %T (RSA key is required)", key)
+	}
+
+	switch signer.Public().(type) {
+	case *rsa.PublicKey:
+		return signingMethodRS256Signer, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type: %s (RSA key is required)", keyTypeName(signer.Public()))
+	}
+}
+
+// keyTypeNameはエラーメッセージ用に公開鍵から鍵の種類を返します。
+func keyTypeName(key crypto.PublicKey) string {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return "RSA"
+	case *ecdsa.PublicKey:
+		return "ECDSA"
+	case ed25519.PublicKey:
+		return "Ed25519"
+	default:
+		return fmt.Sprintf("%T", key)
+	}
+}
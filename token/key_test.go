@@ -0,0 +1,203 @@
+package token
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var (
+	testKeyOnce sync.Once
+	testKey     *rsa.PrivateKey
+)
+
+// getTestKeyはテストで共有するRSA鍵を返します。生成に時間がかかるため1回だけ生成します。
+func getTestKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	testKeyOnce.Do(func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("failed to generate key: %v", err)
+		}
+		testKey = key
+	})
+	if testKey == nil {
+		t.Fatal("test key is not available")
+	}
+	return testKey
+}
+
+// pkcs1Pemはテスト用の鍵をPKCS#1形式のPEMにして返します。
+func pkcs1Pem(t *testing.T) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(getTestKey(t))})
+}
+
+// pkcs8Pemはテスト用の鍵をPKCS#8形式のPEMにして返します。
+func pkcs8Pem(t *testing.T) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(getTestKey(t))
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// encryptedPemはテスト用の鍵をProc-Typeヘッダで暗号化したPEMにして返します。
+func encryptedPem(t *testing.T, passphrase string) []byte {
+	block, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(getTestKey(t)), []byte(passphrase), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatalf("failed to encrypt key: %v", err)
+	}
+	return pem.EncodeToMemory(block)
+}
+
+// isZeroはdataがすべて0かどうかを返します。
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReadPrivateKeyZeroesSecret(t *testing.T) {
+	tests := []struct {
+		name       string
+		secret     func(t *testing.T) []byte
+		passphrase string
+	}{
+		{name: "pkcs1", secret: pkcs1Pem},
+		{name: "pkcs8", secret: pkcs8Pem},
+		{name: "encrypted", secret: func(t *testing.T) []byte { return encryptedPem(t, "secret") }, passphrase: "secret"},
+		{name: "base64", secret: func(t *testing.T) []byte {
+			decoded, err := decodePrivateKeyBase64(base64.StdEncoding.EncodeToString(pkcs1Pem(t)))
+			if err != nil {
+				t.Fatalf("failed to decode base64 key: %v", err)
+			}
+			return decoded
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			secret := test.secret(t)
+			args := &AccessToken{Passphrase: test.passphrase}
+
+			keys, err := args.readPrivateKey(secret)
+			if err != nil {
+				t.Fatalf("readPrivateKey() error = %v", err)
+			}
+			if len(keys) != 1 {
+				t.Fatalf("readPrivateKey() returned %d keys, want 1", len(keys))
+			}
+			if !isZero(secret) {
+				t.Error("secret is not zeroed after parsing")
+			}
+		})
+	}
+}
+
+func TestParsePrivateKeyZeroesBlock(t *testing.T) {
+	block, _ := pem.Decode(encryptedPem(t, "secret"))
+	args := &AccessToken{Passphrase: "secret"}
+
+	_, err := args.parsePrivateKey(block)
+	if err != nil {
+		t.Fatalf("parsePrivateKey() error = %v", err)
+	}
+	if !isZero(block.Bytes) {
+		t.Error("encrypted block is not zeroed after parsing")
+	}
+}
+
+func TestParsePrivateKeyDerZeroesDer(t *testing.T) {
+	pkcs8Der, err := x509.MarshalPKCS8PrivateKey(getTestKey(t))
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		blockType string
+		der       []byte
+	}{
+		{name: "pkcs1", blockType: "RSA PRIVATE KEY", der: x509.MarshalPKCS1PrivateKey(getTestKey(t))},
+		{name: "pkcs8", blockType: "PRIVATE KEY", der: pkcs8Der},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parsePrivateKeyDer(test.blockType, test.der)
+			if err != nil {
+				t.Fatalf("parsePrivateKeyDer() error = %v", err)
+			}
+			if !isZero(test.der) {
+				t.Error("der is not zeroed after parsing")
+			}
+		})
+	}
+}
+
+func TestGetSigningKeysKeepsPrivateKey(t *testing.T) {
+	privateKey := pkcs1Pem(t)
+	original := append([]byte{}, privateKey...)
+	args := &AccessToken{PrivateKey: privateKey}
+
+	_, err := args.getSigningKeys()
+	if err != nil {
+		t.Fatalf("getSigningKeys() error = %v", err)
+	}
+	if !bytes.Equal(args.PrivateKey, original) {
+		t.Error("PrivateKey is modified by getSigningKeys")
+	}
+}
+
+func TestReadPrivateKeyWithoutPemBlock(t *testing.T) {
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("certificate")})
+	publicKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("public key")})
+
+	tests := []struct {
+		name   string
+		secret []byte
+		want   string
+	}{
+		{name: "not pem", secret: []byte("MIIEowIBAAKCAQEA"), want: "file does not contain a valid PEM block"},
+		{name: "empty", secret: []byte{}, want: "file does not contain a valid PEM block"},
+		{name: "only certificate", secret: certificate, want: "file does not contain a valid PEM private key block, only CERTIFICATE"},
+		{name: "only non-key blocks", secret: append(certificate, publicKey...), want: "file does not contain a valid PEM private key block, only CERTIFICATE, PUBLIC KEY"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := &AccessToken{}
+
+			_, err := args.readPrivateKey(test.secret)
+			if err == nil {
+				t.Fatal("readPrivateKey() error = nil")
+			}
+			if !strings.Contains(err.Error(), "does not contain a valid PEM") || !strings.HasPrefix(err.Error(), test.want) {
+				t.Errorf("readPrivateKey() error = %q, want %q", err.Error(), test.want)
+			}
+		})
+	}
+}
+
+func TestReadPrivateKeySkipsNonKeyBlocks(t *testing.T) {
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("certificate")})
+	args := &AccessToken{}
+
+	keys, err := args.readPrivateKey(append(certificate, pkcs1Pem(t)...))
+	if err != nil {
+		t.Fatalf("readPrivateKey() error = %v", err)
+	}
+	if len(keys) != 1 {
+		t.Errorf("readPrivateKey() returned %d keys, want 1", len(keys))
+	}
+}
@@ -0,0 +1,48 @@
+// Package oauth2 はtoken.TokenSourceをgolang.org/x/oauth2のTokenSourceとして使用できるようにします。
+// go-githubなどoauth2に対応したクライアントでインストールアクセストークンを使用するためのものです。
+// tokenパッケージがoauth2に依存しないよう、別のパッケージにしています。
+//
+//	source := oauth2.NewTokenSource(ctx, token.NewTokenSource(&token.AccessToken{...}))
+//	client := github.NewClient(xoauth2.NewClient(ctx, source))
+package oauth2
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zerosspec-dev/github-app-token/token"
+	xoauth2 "golang.org/x/oauth2"
+)
+
+// tokenSourceはtoken.TokenSourceをoauth2.TokenSourceとして使用するためのアダプタです。
+type tokenSource struct {
+	ctx    context.Context
+	source *token.TokenSource
+}
+
+// NewTokenSourceはsourceからトークンを取得するoauth2.TokenSourceを返します。
+// トークンの取得にはctxを使用します。
+func NewTokenSource(ctx context.Context, source *token.TokenSource) xoauth2.TokenSource {
+	return &tokenSource{ctx: ctx, source: source}
+}
+
+// Tokenはインストールアクセストークンをoauth2.Tokenとして返します。
+// Expiryにはトークンのexpires_atを設定します。
+func (adapter *tokenSource) Token() (*xoauth2.Token, error) {
+	result, err := adapter.source.Token(adapter.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	expiry, err := time.Parse(time.RFC3339, result.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("malformed expires_at %q: %w", result.ExpiresAt, err)
+	}
+
+	return &xoauth2.Token{
+		AccessToken: result.Token,
+		TokenType:   "Bearer",
+		Expiry:      expiry,
+	}, nil
+}
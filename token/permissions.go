@@ -0,0 +1,215 @@
+package token
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// knownPermissionsはアクセストークンに指定できる権限の一覧です。
+var knownPermissions = map[string]bool{
+	"actions":                              true,
+	"administration":                       true,
+	"checks":                               true,
+	"codespaces":                           true,
+	"contents":                             true,
+	"dependabot_secrets":                   true,
+	"deployments":                          true,
+	"environments":                         true,
+	"issues":                               true,
+	"metadata":                             true,
+	"packages":                             true,
+	"pages":                                true,
+	"pull_requests":                        true,
+	"repository_custom_properties":         true,
+	"repository_hooks":                     true,
+	"repository_projects":                  true,
+	"secret_scanning_alerts":               true,
+	"secrets":                              true,
+	"security_events":                      true,
+	"single_file":                          true,
+	"statuses":                             true,
+	"vulnerability_alerts":                 true,
+	"workflows":                            true,
+	"members":                              true,
+	"organization_administration":          true,
+	"organization_custom_roles":            true,
+	"organization_custom_org_roles":        true,
+	"organization_custom_properties":       true,
+	"organization_copilot_seat_management": true,
+	"organization_announcement_banners":    true,
+	"organization_events":                  true,
+	"organization_hooks":                   true,
+	"organization_personal_access_tokens":  true,
+	"organization_personal_access_token_requests": true,
+	"organization_plan":                           true,
+	"organization_projects":                       true,
+	"organization_packages":                       true,
+	"organization_secrets":                        true,
+	"organization_self_hosted_runners":            true,
+	"organization_user_blocking":                  true,
+	"team_discussions":                            true,
+	"email_addresses":                             true,
+	"followers":                                   true,
+	"git_ssh_keys":                                true,
+	"gpg_keys":                                    true,
+	"interaction_limits":                          true,
+	"profile":                                     true,
+	"starring":                                    true,
+}
+
+// permissionLevelsは権限のレベルの強さです。
+var permissionLevels = map[string]int{
+	"read":  1,
+	"write": 2,
+	"admin": 3,
+}
+
+// ParsePermissionsは"key:level,key:level"形式の文字列を権限のmapに変換します。
+func ParsePermissions(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	permissions := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		kv := strings.Split(strings.TrimSpace(pair), ":")
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, wrapError(KindInvalidArgument, fmt.Errorf("malformed permission %q: expected key:level", pair))
+		}
+
+		permissions[kv[0]] = kv[1]
+	}
+
+	err := validatePermissions(permissions)
+	if err != nil {
+		return nil, wrapError(KindInvalidArgument, err)
+	}
+
+	return permissions, nil
+}
+
+// validatePermissionsは権限の名前とレベルが正しいかどうかを検証します。
+func validatePermissions(permissions map[string]string) error {
+	for key, level := range permissions {
+		if !knownPermissions[key] {
+			return fmt.Errorf("unknown permission %q", key)
+		}
+		if level != "read" && level != "write" && level != "admin" {
+			return fmt.Errorf("invalid level %q for permission %q: expected read, write or admin", level, key)
+		}
+	}
+
+	return nil
+}
+
+// validateSingleFileはsingle_file権限とファイルのパスの組み合わせを検証します。
+// single_fileはreadまたはwriteのみで、パスを指定する場合はsingle_file権限も要求する必要があります。
+func validateSingleFile(permissions map[string]string, path string) error {
+	level, ok := permissions["single_file"]
+	if ok && level != "read" && level != "write" {
+		return fmt.Errorf("invalid level %q for permission \"single_file\": expected read or write", level)
+	}
+	if path != "" && !ok {
+		return fmt.Errorf("single file %q requires the single_file:read or single_file:write permission", path)
+	}
+	return nil
+}
+
+// ParseRepositoriesは"repo1,repo2"形式の文字列をリポジトリ名の一覧に変換します。
+func ParseRepositories(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	repositories := []string{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, wrapError(KindInvalidArgument, fmt.Errorf("malformed repositories %q: empty repository name", value))
+		}
+		repositories = append(repositories, name)
+	}
+
+	return repositories, nil
+}
+
+// ParseRepositoryIdsは"123,456"形式の文字列をリポジトリIDの一覧に変換します。
+func ParseRepositoryIds(value string) ([]int, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	ids := []int{}
+	for _, item := range strings.Split(value, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil || id <= 0 {
+			return nil, wrapError(KindInvalidArgument, fmt.Errorf("malformed repository id %q: expected a positive integer", item))
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// findDowngradedPermissionsは要求した権限のうち、付与されなかったものや
+// 要求より低いレベルで付与されたものを説明する文字列を名前順に返します。
+func findDowngradedPermissions(requested map[string]string, granted map[string]string) []string {
+	downgraded := []string{}
+	for key, level := range requested {
+		grantedLevel, ok := granted[key]
+		switch {
+		case !ok:
+			downgraded = append(downgraded, fmt.Sprintf("%s:%s was not granted", key, level))
+		case permissionLevels[grantedLevel] < permissionLevels[level]:
+			downgraded = append(downgraded, fmt.Sprintf("%s:%s was granted as %s", key, level, grantedLevel))
+		}
+	}
+
+	sort.Strings(downgraded)
+	return downgraded
+}
+
+// PermissionDiffは要求した権限と実際に付与された権限の比較結果です。
+// RequestedまたはGrantedが空の場合は要求していない、または付与されていないことを示します。
+type PermissionDiff struct {
+	Name      string `json:"permission"`
+	Requested string `json:"requested"`
+	Granted   string `json:"granted"`
+	// Statusはok、downgraded(要求より低いレベルで付与)、missing(付与されていない)
+	// またはextra(要求していないが付与)のいずれかです。
+	Status string `json:"status"`
+}
+
+// DiffPermissionsは要求した権限と付与された権限を比較し、権限の名前順に返します。
+func DiffPermissions(requested map[string]string, granted map[string]string) []PermissionDiff {
+	names := []string{}
+	for name := range requested {
+		names = append(names, name)
+	}
+	for name := range granted {
+		if _, ok := requested[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	diffs := []PermissionDiff{}
+	for _, name := range names {
+		diff := PermissionDiff{Name: name, Requested: requested[name], Granted: granted[name]}
+		switch {
+		case diff.Requested == "":
+			diff.Status = "extra"
+		case diff.Granted == "":
+			diff.Status = "missing"
+		case permissionLevels[diff.Granted] < permissionLevels[diff.Requested]:
+			diff.Status = "downgraded"
+		default:
+			diff.Status = "ok"
+		}
+		diffs = append(diffs, diff)
+	}
+
+	return diffs
+}
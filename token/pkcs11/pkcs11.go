@@ -0,0 +1,32 @@
+// Package pkcs11 はPKCS#11モジュール(HSM)上のRSA秘密鍵をcrypto.Signerとして扱います。
+//
+// cgoが必要なため、使用するには-tags pkcs11を付けてビルドします。
+// タグなしでビルドした場合、Openは常にErrNotSupportedを返します。
+package pkcs11
+
+import (
+	"crypto"
+	"errors"
+)
+
+// ErrNotSupportedはPKCS#11に対応せずにビルドされている場合のエラーです。
+var ErrNotSupported = errors.New("pkcs11 is not supported in this build, rebuild with -tags pkcs11")
+
+// ConfigはPKCS#11モジュール上の鍵の場所です。
+type Config struct {
+	// Moduleは読み込むPKCS#11モジュール(.so)のパスです。
+	Module string
+	// Slotは鍵が格納されているスロットのIDです。
+	Slot uint
+	// Labelは秘密鍵と公開鍵のCKA_LABELです。
+	Label string
+	// Pinはトークンにログインする際のユーザーPINです。空の場合はログインしません。
+	Pin string
+}
+
+// SignerはPKCS#11モジュール上の秘密鍵で署名するcrypto.Signerです。
+// 使用後はCloseでセッションを閉じる必要があります。
+type Signer interface {
+	crypto.Signer
+	Close() error
+}
@@ -0,0 +1,165 @@
+//go:build pkcs11 && cgo
+
+package pkcs11
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// sha256DigestInfoPrefixはPKCS#1 v1.5署名でSHA-256のハッシュ値の前に付けるDigestInfoです。
+// CKM_RSA_PKCSはパディングのみを行うため、呼び出し側で付ける必要があります。
+var sha256DigestInfoPrefix = []byte{
+	0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01,
+	0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20,
+}
+
+type signer struct {
+	module     *pkcs11.Ctx
+	session    pkcs11.SessionHandle
+	privateKey pkcs11.ObjectHandle
+	publicKey  *rsa.PublicKey
+	opened     bool
+	loggedIn   bool
+
+	// 1つのセッションで同時に署名することはできないため排他する
+	mutex sync.Mutex
+}
+
+// OpenはPKCS#11モジュールを読み込み、configで指定された鍵で署名するSignerを返します。
+func Open(config Config) (Signer, error) {
+	module := pkcs11.New(config.Module)
+	if module == nil {
+		return nil, fmt.Errorf("failed to load pkcs11 module %s", config.Module)
+	}
+
+	err := module.Initialize()
+	if err != nil {
+		module.Destroy()
+		return nil, fmt.Errorf("failed to initialize pkcs11 module: %w", err)
+	}
+
+	s := &signer{module: module}
+	err = s.open(config)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// openはセッションを開いて鍵を探します。
+func (s *signer) open(config Config) error {
+	session, err := s.module.OpenSession(config.Slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return fmt.Errorf("failed to open pkcs11 session on slot %d: %w", config.Slot, err)
+	}
+	s.session = session
+	s.opened = true
+
+	if config.Pin != "" {
+		err = s.module.Login(session, pkcs11.CKU_USER, config.Pin)
+		if err != nil {
+			return fmt.Errorf("failed to login to pkcs11 token: %w", err)
+		}
+		s.loggedIn = true
+	}
+
+	s.privateKey, err = s.findObject(pkcs11.CKO_PRIVATE_KEY, config.Label)
+	if err != nil {
+		return err
+	}
+
+	publicKey, err := s.findObject(pkcs11.CKO_PUBLIC_KEY, config.Label)
+	if err != nil {
+		return err
+	}
+
+	attributes, err := s.module.GetAttributeValue(session, publicKey, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read public key %q: %w", config.Label, err)
+	}
+
+	s.publicKey = &rsa.PublicKey{
+		N: new(big.Int).SetBytes(attributes[0].Value),
+		E: int(new(big.Int).SetBytes(attributes[1].Value).Int64()),
+	}
+
+	return nil
+}
+
+// findObjectはlabelに一致するclassのオブジェクトを探します。
+func (s *signer) findObject(class uint, label string) (pkcs11.ObjectHandle, error) {
+	err := s.module.FindObjectsInit(s.session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer s.module.FindObjectsFinal(s.session)
+
+	objects, _, err := s.module.FindObjects(s.session, 2)
+	if err != nil {
+		return 0, err
+	}
+
+	switch len(objects) {
+	case 0:
+		return 0, fmt.Errorf("rsa key %q is not found in pkcs11 token", label)
+	case 1:
+		return objects[0], nil
+	default:
+		return 0, fmt.Errorf("multiple rsa keys labeled %q are found in pkcs11 token", label)
+	}
+}
+
+func (s *signer) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// SignはRSASSA-PKCS1-v1_5(SHA-256)でdigestに署名します。
+func (s *signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if _, ok := opts.(*rsa.PSSOptions); ok || opts.HashFunc() != crypto.SHA256 {
+		return nil, fmt.Errorf("pkcs11 signer supports only PKCS#1 v1.5 with SHA-256")
+	}
+
+	data := make([]byte, 0, len(sha256DigestInfoPrefix)+len(digest))
+	data = append(data, sha256DigestInfoPrefix...)
+	data = append(data, digest...)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := s.module.SignInit(s.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)}, s.privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.module.Sign(s.session, data)
+}
+
+// Closeはセッションを閉じてモジュールを解放します。
+func (s *signer) Close() error {
+	if s.loggedIn {
+		s.module.Logout(s.session)
+	}
+	if s.opened {
+		s.module.CloseSession(s.session)
+	}
+
+	err := s.module.Finalize()
+	s.module.Destroy()
+	return err
+}
@@ -0,0 +1,8 @@
+//go:build !pkcs11 || !cgo
+
+package pkcs11
+
+// OpenはPKCS#11に対応せずにビルドされているため常にErrNotSupportedを返します。
+func Open(config Config) (Signer, error) {
+	return nil, ErrNotSupported
+}
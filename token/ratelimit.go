@@ -0,0 +1,43 @@
+package token
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimitはGitHub APIのレスポンスヘッダに含まれるレート制限の状態です。
+type RateLimit struct {
+	// Resourceはレート制限の対象の種類です。(例: core)
+	Resource string `json:"resource,omitempty"`
+	// Limitは期間内に送信できるリクエストの上限です。
+	Limit int `json:"limit"`
+	// Remainingは期間内に送信できる残りのリクエスト数です。
+	Remaining int `json:"remaining"`
+	// Usedは期間内に送信したリクエスト数です。
+	Used int `json:"used"`
+	// Resetは残りのリクエスト数がリセットされる時刻です。
+	Reset time.Time `json:"reset"`
+}
+
+// parseRateLimitはX-RateLimit-*ヘッダからレート制限の状態を読み取ります。
+// ヘッダが無い場合はnilを返します。
+func parseRateLimit(header http.Header) *RateLimit {
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return nil
+	}
+
+	// 残りのリクエスト数以外は参考情報なので読み取れない場合は0のままにする
+	rateLimit := &RateLimit{
+		Resource:  header.Get("X-RateLimit-Resource"),
+		Remaining: remaining,
+	}
+	rateLimit.Limit, _ = strconv.Atoi(header.Get("X-RateLimit-Limit"))
+	rateLimit.Used, _ = strconv.Atoi(header.Get("X-RateLimit-Used"))
+	if reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		rateLimit.Reset = time.Unix(reset, 0).UTC()
+	}
+
+	return rateLimit
+}
@@ -0,0 +1,131 @@
+package token
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// redactedは秘匿情報を置き換える文字列です。
+const redacted = "***"
+
+// sensitiveHeadersはログに値を出力しないヘッダです。
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+}
+
+// sensitiveFieldsはログに値を出力しないjsonのフィールドです。
+var sensitiveFields = map[string]bool{
+	"token":         true,
+	"access_token":  true,
+	"refresh_token": true,
+	"device_code":   true,
+}
+
+// secretPatternsはGitHubのトークンとJWTの形式です。
+// エラーメッセージなどにトークンがそのまま含まれていた場合に伏せるために使用します。
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`gh[pousr]_[A-Za-z0-9_]{16,}`),
+	regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]*`),
+}
+
+// RedactSecretsはtextに含まれるGitHubのトークンとJWTを伏せて返します。
+func RedactSecrets(text string) string {
+	for _, pattern := range secretPatterns {
+		text = pattern.ReplaceAllString(text, redacted)
+	}
+	return text
+}
+
+// redactFormはtextに含まれるGitHubのトークンとJWT、valuesのうち秘匿情報のフィールドの値を伏せて返します。
+// OAuthのエンドポイントは送信したデバイスコードをエラーの説明に含めることがあるためです。
+func redactForm(text string, values url.Values) string {
+	for name, value := range values {
+		if !sensitiveFields[name] {
+			continue
+		}
+		for _, v := range value {
+			if v != "" {
+				text = strings.ReplaceAll(text, v, redacted)
+			}
+		}
+	}
+	return RedactSecrets(text)
+}
+
+// redactHeaderは秘匿情報を伏せたヘッダをログ用の文字列にして返します。
+// customに含まれるヘッダは利用者が追加したもので、認証情報であることも多いため同じく伏せます。
+func redactHeader(header http.Header, custom http.Header) string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		value := strings.Join(header[name], ", ")
+		if sensitiveHeaders[http.CanonicalHeaderKey(name)] || hasHeader(custom, name) {
+			value = redacted
+		}
+		pairs = append(pairs, fmt.Sprintf("%s: %s", name, value))
+	}
+
+	return strings.Join(pairs, "; ")
+}
+
+// hasHeaderはheaderにnameのヘッダが含まれるかどうかを返します。
+// headerは利用者が直接組み立てることもあり、キーが正規化されているとは限らないため大文字小文字を区別せずに比較します。
+func hasHeader(header http.Header, name string) bool {
+	for key := range header {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactBodyはトークンなどの秘匿情報を伏せたレスポンスボディをログ用の文字列にして返します。
+// jsonとして解釈できない場合は内容を出力せず長さのみを返します。
+func redactBody(body []byte) string {
+	var value interface{}
+	err := json.Unmarshal(body, &value)
+	if err != nil {
+		return fmt.Sprintf("(%d bytes)", len(body))
+	}
+
+	encoded, err := json.Marshal(redactValue(value))
+	if err != nil {
+		return fmt.Sprintf("(%d bytes)", len(body))
+	}
+
+	return string(encoded)
+}
+
+// redactValueはjsonの値に含まれる秘匿情報のフィールドを再帰的に伏せます。
+// エラーのメッセージがトークンを含むこともあるため、その他の文字列もトークンやJWTの形式の部分を伏せます。
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return RedactSecrets(v)
+	case map[string]interface{}:
+		for key, field := range v {
+			if sensitiveFields[key] {
+				v[key] = redacted
+			} else {
+				v[key] = redactValue(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(item)
+		}
+	}
+
+	return value
+}
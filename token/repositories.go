@@ -0,0 +1,79 @@
+package token
+
+import (
+	"context"
+	"net/http"
+)
+
+// Repositoryはインストールアクセストークンでアクセスできるリポジトリです。
+type Repository struct {
+	Id       int    `json:"id"`
+	FullName string `json:"full_name"`
+	Private  bool   `json:"private"`
+}
+
+type installationRepositoriesApiResponse struct {
+	Repositories []Repository `json:"repositories"`
+}
+
+// ListRepositoriesはトークンを取得し、そのトークンでアクセスできるリポジトリの一覧を返します。
+func (args *AccessToken) ListRepositories() ([]Repository, error) {
+	return args.ListRepositoriesContext(context.Background())
+}
+
+// ListRepositoriesContextはctxを使用してトークンを取得し、そのトークンでアクセスできるリポジトリの一覧を返します。
+// RepositoriesやPermissionsで絞り込んだ場合は絞り込んだ結果のリポジトリのみを返します。
+// 結果が複数ページに分かれている場合はLinkヘッダのrel="next"をたどってすべて取得します。
+func (args *AccessToken) ListRepositoriesContext(ctx context.Context) ([]Repository, error) {
+	apiUrl, err := args.getApiUrl()
+	if err != nil {
+		return nil, err
+	}
+
+	// トークンの取得と一覧の取得で同じクライアントを使用し、接続と設定を共有する
+	client, err := args.newHttpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := args.getContext(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return args.listRepositories(ctx, client, apiUrl, token.Token)
+}
+
+// ListRepositoriesForTokenは取得済みのインストールアクセストークンでアクセスできるリポジトリの一覧を返します。
+// トークンは取得しないため、秘密鍵やインストールの指定は不要です。
+func (args *AccessToken) ListRepositoriesForToken(ctx context.Context, token string) ([]Repository, error) {
+	apiUrl, err := args.getApiUrl()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := args.newHttpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return args.listRepositories(ctx, client, apiUrl, token)
+}
+
+// listRepositoriesはtokenで認証して/installation/repositoriesから順にすべてのページを取得します。
+func (args *AccessToken) listRepositories(ctx context.Context, client *http.Client, apiUrl string, token string) ([]Repository, error) {
+	repositories := []Repository{}
+	listApiUrl := apiUrl + "/installation/repositories" + args.getPerPageQuery()
+	for listApiUrl != "" {
+		response := installationRepositoriesApiResponse{}
+		meta, err := args.sendWithMeta(ctx, client, bearerToken(token), "GET", &listApiUrl, nil, &response)
+		if err != nil {
+			return nil, err
+		}
+
+		repositories = append(repositories, response.Repositories...)
+		listApiUrl = meta.NextLink
+	}
+
+	return repositories, nil
+}
@@ -0,0 +1,462 @@
+package token
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sendはリクエストの結果をtargetにマップします。targetがnilの場合は結果を読み捨てます。
+// bodyがnilでない場合はjsonにしてリクエストボディとして送信します。
+// 5xxのレスポンスや通信エラーの場合はMaxRetriesまで再送します。
+// レート制限(403/429)でRetry-Afterが返された場合は指定された時間だけ待って再送します。
+// clientは1回の操作の中で共有し、接続や設定を再利用します。
+func (args *AccessToken) send(ctx context.Context, client *http.Client, authorization authorizer, method string, url *string, body interface{}, target interface{}) error {
+	_, err := args.sendWithMeta(ctx, client, authorization, method, url, body, target)
+	return err
+}
+
+// responseMetaはレスポンスのうち本文以外で呼び出し側が参照する情報です。
+type responseMeta struct {
+	Header http.Header
+	// NextLinkはLinkヘッダのrel="next"のURLです。次のページが無い場合は空です。
+	NextLink string
+	// RateLimitはレート制限の状態です。ヘッダが無い場合はnilです。
+	RateLimit *RateLimit
+}
+
+// newResponseMetaはレスポンスヘッダからresponseMetaを作成します。
+func newResponseMeta(header http.Header) *responseMeta {
+	return &responseMeta{
+		Header:    header,
+		NextLink:  getNextLink(header),
+		RateLimit: parseRateLimit(header),
+	}
+}
+
+// sendWithMetaはsendと同様にリクエストを送信し、成功した場合はレスポンスヘッダの情報も返します。
+// ページネーションのLinkヘッダやレート制限を参照する場合に使用します。
+func (args *AccessToken) sendWithMeta(ctx context.Context, client *http.Client, authorization authorizer, method string, url *string, body interface{}, target interface{}) (*responseMeta, error) {
+	var encoded []byte
+	if body != nil {
+		var err error
+		encoded, err = json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	maxRetries := args.MaxRetries
+
+	maxRetryWait := time.Minute
+	if args.MaxRetryWait > 0 {
+		maxRetryWait = args.MaxRetryWait
+	}
+
+	for attempt := 0; ; attempt++ {
+		meta, retryable, retryAfter, err := args.sendOnce(ctx, client, authorization, method, url, encoded, target)
+		if err == nil || !retryable || attempt >= maxRetries {
+			return meta, err
+		}
+
+		if retryAfter > maxRetryWait {
+			return nil, fmt.Errorf("%w: rate limited, retry requested after %s which exceeds max-retry-wait %s", err, retryAfter, maxRetryWait)
+		}
+
+		delay := retryAfter
+		if delay <= 0 {
+			delay = args.getRetryDelay(attempt)
+		}
+
+		args.debug("retrying request", Field{"method", method}, Field{"url", *url}, Field{"attempt", attempt + 1}, Field{"delay", delay.String()}, Field{"error", err.Error()})
+
+		// 待っている間にキャンセルされた場合は直ちに終了する
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, wrapError(KindNetwork, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// parseRetryAfterはRetry-Afterヘッダの値を待ち時間に変換します。
+// 秒数の形式とHTTP-dateの形式に対応します。
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+
+	delay := date.Sub(now)
+	if delay < 0 {
+		delay = 0
+	}
+	return delay, true
+}
+
+// getRetryDelayはattempt回目の再送までの待ち時間を返します。
+// 待ち時間の上限は指数関数的に増加してRetryMaxDelayで頭打ちになり、
+// 多数のジョブが同時に再送しないよう0から上限までの間でランダムに選びます。(full jitter)
+func (args *AccessToken) getRetryDelay(attempt int) time.Duration {
+	base := time.Second
+	if args.RetryBaseDelay > 0 {
+		base = args.RetryBaseDelay
+	}
+
+	maxDelay := DefaultRetryMaxDelay
+	if args.RetryMaxDelay > 0 {
+		maxDelay = args.RetryMaxDelay
+	}
+
+	// シフトで桁あふれした場合も上限を使用する
+	delay := base << uint(attempt)
+	if delay <= 0 || delay > maxDelay || attempt >= 63 {
+		delay = maxDelay
+	}
+
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// newHttpClientはTimeout、プロキシ、TLSの設定をしたHTTPクライアントを返します。
+// HttpClientが指定されている場合はそれを返します。設定の誤りは引数の誤りとして返します。
+func (args *AccessToken) newHttpClient() (*http.Client, error) {
+	if args.HttpClient != nil {
+		return args.HttpClient, nil
+	}
+
+	client, err := args.buildHttpClient()
+	if err != nil {
+		return nil, wrapError(KindInvalidArgument, err)
+	}
+	return client, nil
+}
+
+// buildHttpClientは設定に従ってHTTPクライアントを組み立てます。
+func (args *AccessToken) buildHttpClient() (*http.Client, error) {
+	timeout := 30 * time.Second
+	if args.Timeout > 0 {
+		timeout = args.Timeout
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	// プロキシが指定された場合は環境変数より優先する
+	transport.Proxy = http.ProxyFromEnvironment
+	if args.Proxy != "" {
+		proxyUrl, err := parseProxyUrl(args.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+
+	// CA証明書が指定されていない場合はシステムの証明書を使用する
+	tlsConfig := &tls.Config{}
+	if args.CaCertPath != "" {
+		pool, err := loadCertPool(args.CaCertPath)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if args.InsecureSkipVerify {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}, nil
+}
+
+// loadCertPoolはPEM形式のCA証明書バンドルを読み込んで返します。
+func loadCertPool(path string) (*x509.CertPool, error) {
+	bundle, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ca certificate: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("no valid certificates found in %s", path)
+	}
+
+	return pool, nil
+}
+
+// parseProxyUrlはプロキシのURLを検証して返します。
+func parseProxyUrl(value string) (*url.URL, error) {
+	proxyUrl, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url: %w", err)
+	}
+
+	switch proxyUrl.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid proxy url %q: scheme must be http, https or socks5", value)
+	}
+	if proxyUrl.Host == "" {
+		return nil, fmt.Errorf("invalid proxy url %q: host is missing", value)
+	}
+
+	return proxyUrl, nil
+}
+
+// wrapTimeoutErrorはタイムアウトによるエラーを認証エラーなどと区別できるメッセージにします。
+func wrapTimeoutError(client *http.Client, err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		// HttpClientで指定されたクライアントはTimeoutが設定されていない場合がある
+		if client.Timeout == 0 {
+			return fmt.Errorf("request timed out: %w", err)
+		}
+		return fmt.Errorf("request timed out after %s: %w", client.Timeout, err)
+	}
+	return err
+}
+
+// isRetryableNetworkErrorは通信エラーが再送で回復する可能性があるかどうかを返します。
+// 名前解決の失敗は設定の誤りであることも多く、すぐに失敗させたい場合があるため、
+// 一時的な失敗のみRetryDnsが指定されている場合に再送します。
+func (args *AccessToken) isRetryableNetworkError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return args.RetryDns && (dnsErr.Temporary() || dnsErr.IsTimeout)
+	}
+	return true
+}
+
+// reservedHeadersはHeadersで上書きできないヘッダです。
+var reservedHeaders = map[string]bool{
+	"Authorization": true,
+	"Accept":        true,
+	"Content-Type":  true,
+}
+
+// IsReservedHeaderはnameがHeadersで上書きできないヘッダかどうかを返します。
+// 認証に使用するAuthorizationと、リクエストと応答の形式を決めるAcceptとContent-Typeが該当します。
+func IsReservedHeader(name string) bool {
+	return reservedHeaders[http.CanonicalHeaderKey(name)]
+}
+
+// setCustomHeadersはHeadersのヘッダをheaderに設定します。上書きできないヘッダは無視します。
+func (args *AccessToken) setCustomHeaders(header http.Header) {
+	for name, values := range args.Headers {
+		if IsReservedHeader(name) {
+			continue
+		}
+		header.Del(name)
+		for _, value := range values {
+			header.Add(name, value)
+		}
+	}
+}
+
+// sendOnceはリクエストを1回送信し、結果をtargetにマップします。
+// 成功した場合はレスポンスヘッダの情報を返します。
+// エラーの場合は再送で回復する可能性があるかどうかと、
+// サーバーから指定された再送までの待ち時間も返します。
+func (args *AccessToken) sendOnce(ctx context.Context, client *http.Client, authorization authorizer, method string, url *string, body []byte, target interface{}) (*responseMeta, bool, time.Duration, error) {
+	var requestBody io.Reader
+	if body != nil {
+		requestBody = bytes.NewReader(body)
+	}
+
+	// 送信
+	request, err := http.NewRequestWithContext(ctx, method, *url, requestBody)
+	if err != nil {
+		return nil, false, 0, wrapError(KindInvalidArgument, err)
+	}
+
+	// GitHubはUser-Agentの無いリクエストを拒否する場合がある
+	userAgent := DefaultUserAgent
+	if args.UserAgent != "" {
+		userAgent = args.UserAgent
+	}
+
+	accept := DefaultAccept
+	if args.Accept != "" {
+		accept = args.Accept
+	}
+
+	apiVersion := DefaultApiVersion
+	if args.ApiVersion != "" {
+		apiVersion = args.ApiVersion
+	}
+
+	// 再送までの待ち時間でJWTの有効期限が近づいた場合は署名し直すため、送信のたびに取得する
+	credential, err := authorization.authorization()
+	if err != nil {
+		return nil, false, 0, err
+	}
+
+	request.Header = map[string][]string{
+		"Accept":               {accept},
+		"X-GitHub-Api-Version": {apiVersion},
+		"Authorization":        {fmt.Sprintf("Bearer %s", credential)},
+		"User-Agent":           {userAgent},
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	args.setCustomHeaders(request.Header)
+
+	// Authorizationヘッダと追加のヘッダは常に伏せて出力する
+	args.debug("request", Field{"method", method}, Field{"url", *url}, Field{"headers", redactHeader(request.Header, args.Headers)})
+
+	start := time.Now()
+	response, err := client.Do(request)
+	if err != nil {
+		args.debug("request error", Field{"method", method}, Field{"url", *url}, Field{"error", err.Error()}, Field{"duration_ms", time.Since(start).Milliseconds()})
+
+		// キャンセルされた場合は再送しない
+		if ctx.Err() != nil {
+			return nil, false, 0, wrapError(KindNetwork, err)
+		}
+		return nil, args.isRetryableNetworkError(err), 0, wrapError(KindNetwork, wrapTimeoutError(client, err))
+	}
+
+	defer response.Body.Close()
+
+	args.debug("response", Field{"method", method}, Field{"url", *url}, Field{"status", response.StatusCode}, Field{"duration_ms", time.Since(start).Milliseconds()})
+
+	meta := newResponseMeta(response.Header)
+	if meta.RateLimit != nil && args.RateLimitFunc != nil {
+		args.RateLimitFunc(method, *url, *meta.RateLimit)
+	}
+
+	if response.StatusCode/100 != 2 {
+		responseErr := &ResponseError{
+			Method:     method,
+			Url:        *url,
+			StatusCode: response.StatusCode,
+			Status:     response.Status,
+			RequestId:  response.Header.Get("X-GitHub-Request-Id"),
+		}
+
+		// GitHubはエラーの理由をレスポンスボディのjsonで返す
+		// メッセージが送信したトークンを含むことがあるため、エラーに含める前に伏せる
+		if errorBody := args.readErrorBody(response.Body); errorBody != nil {
+			responseErr.Message = RedactSecrets(errorBody.Message)
+			responseErr.DocumentationUrl = errorBody.DocumentationUrl
+		}
+
+		err := wrapError(getResponseErrorKind(response), responseErr)
+
+		// レート制限の場合はRetry-Afterに従って再送する
+		if response.StatusCode == http.StatusForbidden || response.StatusCode == http.StatusTooManyRequests {
+			if retryAfter, ok := parseRetryAfter(response.Header.Get("Retry-After"), time.Now()); ok {
+				return nil, true, retryAfter, err
+			}
+		}
+
+		// 4xxはJWTの誤りなどクライアント側の問題なので再送しない
+		return nil, response.StatusCode/100 == 5, 0, err
+	}
+
+	// 誤ったURLを指定した場合などに巨大なレスポンスでメモリを使い切らないよう、上限を超えたらエラーにする
+	maxResponseBytes := int64(DefaultMaxResponseBytes)
+	if args.MaxResponseBytes > 0 {
+		maxResponseBytes = args.MaxResponseBytes
+	}
+
+	responseBody, err := ioutil.ReadAll(io.LimitReader(response.Body, maxResponseBytes+1))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, false, 0, wrapError(KindNetwork, err)
+		}
+		return nil, true, 0, wrapError(KindNetwork, wrapTimeoutError(client, err))
+	}
+	if int64(len(responseBody)) > maxResponseBytes {
+		return nil, false, 0, wrapError(KindNetwork, fmt.Errorf("response body is too large: exceeds max-response-bytes %d", maxResponseBytes))
+	}
+
+	// トークンを含むフィールドは常に伏せて出力する
+	if len(responseBody) > 0 {
+		args.debug("response body", Field{"body", redactBody(responseBody)})
+	}
+
+	// 結果が不要な場合(204 No Contentなど)はマッピングしない
+	if target == nil {
+		return meta, false, 0, nil
+	}
+
+	// jsonにマッピングする
+	err = json.Unmarshal(responseBody, target)
+	if err != nil {
+		return nil, false, 0, wrapError(KindNetwork, fmt.Errorf("malformed response: %w", err))
+	}
+
+	return meta, false, 0, nil
+}
+
+// getResponseErrorKindは2xx以外のレスポンスの分類を返します。
+// 403はレート制限の場合と権限不足の場合があるため、レート制限のヘッダで区別します。
+func getResponseErrorKind(response *http.Response) ErrorKind {
+	switch {
+	case response.StatusCode == http.StatusUnauthorized:
+		return KindAuth
+	case response.StatusCode == http.StatusTooManyRequests:
+		return KindRateLimit
+	case response.StatusCode == http.StatusForbidden &&
+		(response.Header.Get("Retry-After") != "" || response.Header.Get("X-RateLimit-Remaining") == "0"):
+		return KindRateLimit
+	default:
+		return KindNetwork
+	}
+}
+
+// maxErrorBodySizeはエラーのレスポンスボディを読み込む上限です。
+const maxErrorBodySize = 64 * 1024
+
+type errorApiResponse struct {
+	Message          string `json:"message"`
+	DocumentationUrl string `json:"documentation_url"`
+}
+
+// readErrorBodyはエラーのレスポンスボディからGitHubのエラーメッセージを取り出します。
+// ボディがjsonでない場合はnilを返します。
+func (args *AccessToken) readErrorBody(body io.Reader) *errorApiResponse {
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxErrorBodySize))
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+
+	args.debug("response body", Field{"body", redactBody(data)})
+
+	errorApiResponse := errorApiResponse{}
+	if json.Unmarshal(data, &errorApiResponse) != nil {
+		return nil
+	}
+
+	return &errorApiResponse
+}
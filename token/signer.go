@@ -0,0 +1,46 @@
+package token
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// signingMethodRS256Signerはcrypto.Signerに署名を委ねるRS256のjwt.SigningMethodです。
+// HSMのように秘密鍵を取り出せない場合にも署名できるようにするためです。
+var signingMethodRS256Signer = &signerMethod{}
+
+type signerMethod struct{}
+
+// signingKeyはJWTの署名に使用する鍵と、鍵の種類に応じて選択した署名方式の組です。
+type signingKey struct {
+	key    crypto.PrivateKey
+	method jwt.SigningMethod
+}
+
+func (m *signerMethod) Alg() string {
+	return jwt.SigningMethodRS256.Alg()
+}
+
+// Signはkeyに渡されたcrypto.Signerで署名します。
+// *rsa.PrivateKeyもcrypto.Signerなので、ファイルから読み込んだ鍵も同じ経路で署名されます。
+func (m *signerMethod) Sign(signingString string, key interface{}) ([]byte, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, jwt.ErrInvalidKeyType
+	}
+	if _, ok := signer.Public().(*rsa.PublicKey); !ok {
+		return nil, fmt.Errorf("unsupported signer public key type: %T (RSA key is required)", signer.Public())
+	}
+
+	digest := sha256.Sum256([]byte(signingString))
+	return signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+func (m *signerMethod) Verify(signingString string, sig []byte, key interface{}) error {
+	return jwt.SigningMethodRS256.Verify(signingString, sig, key)
+}
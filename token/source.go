@@ -0,0 +1,103 @@
+package token
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// DefaultRefreshWindowはキャッシュやTokenSourceがトークンを取得し直す有効期限までの残り時間の既定値です。
+const DefaultRefreshWindow = 5 * time.Minute
+
+// TokenLifetimeはGitHubが発行するインストールアクセストークンの有効期間です。
+const TokenLifetime = time.Hour
+
+// getRefreshMarginはトークンを取得し直す有効期限までの残り時間を返します。
+func (args *AccessToken) getRefreshMargin() time.Duration {
+	if args.RefreshMargin > 0 {
+		return args.RefreshMargin
+	}
+	return DefaultRefreshWindow
+}
+
+// validateRefreshMarginはRefreshMarginがトークンの有効期間より短いことを確認します。
+// 有効期間以上の場合は取得した直後のトークンも再利用できなくなるためです。
+func (args *AccessToken) validateRefreshMargin() error {
+	if args.RefreshMargin < 0 || args.RefreshMargin >= TokenLifetime {
+		return wrapError(KindInvalidArgument, fmt.Errorf("refresh margin must be between 0 and %s: %s", TokenLifetime, args.RefreshMargin))
+	}
+	return nil
+}
+
+// jitterRefreshMarginはmarginに最大10%の揺らぎを加えます。
+// 同じキャッシュを使用する複数のプロセスが同時にトークンを取得し直さないようにするためです。
+func jitterRefreshMargin(margin time.Duration) time.Duration {
+	return margin + time.Duration(rand.Int63n(int64(margin)/10+1))
+}
+
+// TokenSourceは取得したトークンを保持し、有効期限が近づくと自動的に取得し直します。
+// 長時間動作するプロセスで有効期限を意識せずにトークンを使用するためのものです。
+// 秘密鍵は最初の取得時にAccessTokenのLoadKeysで読み込み、取得し直す際も同じ鍵を使用します。
+// 複数のゴルーチンから同時に使用できます。
+type TokenSource struct {
+	// AccessTokenはトークンの取得に使用する設定です。
+	AccessToken *AccessToken
+	// RefreshWindowは有効期限までの残り時間がこれを下回った場合にトークンを取得し直す時間です。
+	// 0の場合はAccessTokenのRefreshMarginを使用します。
+	RefreshWindow time.Duration
+
+	mutex     sync.Mutex
+	token     *Token
+	expiresAt time.Time
+}
+
+// NewTokenSourceはargsを使用してトークンを取得するTokenSourceを返します。
+func NewTokenSource(args *AccessToken) *TokenSource {
+	return &TokenSource{AccessToken: args}
+}
+
+// Tokenは有効期限までRefreshWindow以上残っているトークンを返します。
+// 保持しているトークンの残り時間が足りない場合はctxを使用して取得し直します。
+func (source *TokenSource) Token(ctx context.Context) (*Token, error) {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+
+	refreshWindow := source.AccessToken.getRefreshMargin()
+	if source.RefreshWindow > 0 {
+		refreshWindow = source.RefreshWindow
+	}
+
+	if source.token != nil && time.Until(source.expiresAt) > jitterRefreshMargin(refreshWindow) {
+		return source.token, nil
+	}
+
+	err := source.AccessToken.validateRefreshMargin()
+	if err != nil {
+		return nil, err
+	}
+
+	// 標準入力などから読み込んだ鍵は2回目以降に読めないため、最初に読み込んだ鍵を使い続ける
+	if source.AccessToken.keys == nil {
+		err = source.AccessToken.LoadKeys()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	token, err := source.AccessToken.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt, err := token.ExpiresAtTime()
+	if err != nil {
+		return nil, err
+	}
+
+	source.token = token
+	source.expiresAt = expiresAt
+
+	return token, nil
+}
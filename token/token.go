@@ -0,0 +1,809 @@
+// Package token はGitHub Appsのインストールアクセストークンを取得します。
+package token
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// DefaultApiUrlはApiUrlが空の場合に使用するGitHub APIのベースURLです。
+const DefaultApiUrl = "https://api.github.com"
+
+// DefaultAcceptはAcceptが空の場合に送信するAcceptヘッダの値です。
+const DefaultAccept = "application/vnd.github+json"
+
+// DefaultApiVersionはApiVersionが空の場合に送信するX-GitHub-Api-Versionヘッダの値です。
+const DefaultApiVersion = "2022-11-28"
+
+// DefaultUserAgentはUserAgentが空の場合に送信するUser-Agentヘッダの値です。
+const DefaultUserAgent = "github-app-token"
+
+// DefaultMaxResponseBytesはMaxResponseBytesが0の場合に読み込むレスポンスボディの上限です。
+const DefaultMaxResponseBytes = 10 * 1024 * 1024
+
+// DefaultRetryMaxDelayはRetryMaxDelayが0の場合の再送の間隔の上限です。
+const DefaultRetryMaxDelay = 30 * time.Second
+
+// clockSkewRetryBackdateはiatが未来の時刻として拒否された場合に追加で遡らせる時間です。
+const clockSkewRetryBackdate = 5 * time.Minute
+
+const (
+	// DefaultJwtLifetimeはJWTの有効期間の既定値です。
+	DefaultJwtLifetime = 3 * time.Minute
+	// DefaultJwtClockSkewはJWTの発行時刻を遡らせる時間の既定値です。
+	DefaultJwtClockSkew = 1 * time.Minute
+	// MaxJwtLifetimeはGitHubが受け付けるJWTの有効期間の上限です。
+	MaxJwtLifetime = 10 * time.Minute
+)
+
+// AccessTokenはインストールアクセストークンの取得に必要な設定です。
+// 時間を表すフィールドはゼロ値の場合に既定値を使用します。
+type AccessToken struct {
+	// AppIdはGitHub AppsのAppIDです。数値のAppIDの代わりにClient IDを指定することもできます。
+	AppId string
+	// ClientIdはGitHub AppsのClient ID(例: Iv1.abc123)です。
+	// 空でない場合はAppIdの代わりにJWTのissとして使用します。
+	ClientId string
+	// JwtIssuerはJWTのissに設定する値です。空でない場合はAppIdやClientIdの代わりに使用します。
+	// インストールの参照には影響しません。
+	JwtIssuer string
+	// ApiUrlはGitHub APIのベースURLです。空の場合はDefaultApiUrlを使用します。
+	ApiUrl string
+	// WebUrlはデバイスフローで使用するGitHubのベースURLです。空の場合はDefaultWebUrlを使用します。
+	WebUrl string
+	// PemFilePathは秘密鍵のPEMファイルのパスです。"-"の場合は標準入力から読み込みます。
+	PemFilePath string
+	// StrictPermissionsがtrueの場合は要求した権限の一部が付与されなかった場合にエラーにします。
+	// falseの場合は警告のみ出力します。
+	StrictPermissions bool
+	// SkipPermissionsCheckがtrueの場合は要求した権限の一部が付与されなかった場合も警告せず、StrictPermissionsも使用しません。
+	// 付与された権限を呼び出し側で比較して報告する場合に指定します。
+	SkipPermissionsCheck bool
+	// FallbackPemFilePathsは鍵の更新中などに使用する予備の秘密鍵のPEMファイルのパスです。
+	// 先に指定された鍵がGitHubに拒否された(401)場合に順に試します。
+	FallbackPemFilePaths []string
+	// PrivateKeyはPEM形式の秘密鍵の内容です。PemFilePathが空の場合に使用します。
+	PrivateKey []byte
+	// PrivateKeyBase64はbase64でエンコードしたPEM形式の秘密鍵です。
+	// PemFilePathとPrivateKeyが空の場合に使用します。通常の形式とURLセーフな形式、パディングの有無のいずれにも対応します。
+	PrivateKeyBase64 string
+	// Passphraseは暗号化された秘密鍵のパスフレーズです。
+	Passphrase string
+	// SignerはJWTの署名に使用する鍵です。HSM上の鍵などを使用する場合に指定します。
+	// nilでない場合はPemFilePathやPrivateKeyより優先します。
+	Signer crypto.Signer
+	// InstallationIdはGitHub AppsのインストールIDです。
+	// 0でない場合はインストールの参照を省略し、OrganizationNameとRepositoryNameは使用しません。
+	InstallationId int
+	// OrganizationNameはリポジトリのオーナーまたはorg名です。
+	OrganizationName string
+	// RepositoryNameはリポジトリ名です。空の場合はorgへのインストールを参照します。
+	RepositoryName string
+	// TokenUrlはインストールアクセストークンを取得するURLのテンプレートです。
+	// 空でない場合はインストール情報のaccess_tokens_urlの代わりに、{installation_id}をインストールIDに置き換えて使用します。
+	// "/"から始まる場合はApiUrlからのパスとして扱います。
+	// access_tokens_urlを書き換えるプロキシを経由するGHEなどで指定します。
+	TokenUrl string
+	// FallbackOrganizationNameはOrganizationNameでインストールが見つからない(404)場合に参照し直すオーナーです。
+	// リポジトリをorg間で移管した直後は移管元での参照が一時的に404になるため、移管先を指定します。
+	FallbackOrganizationName string
+	// AccountNameはAppがインストールされているユーザーまたはorgのログイン名です。
+	// 空でない場合はOrganizationNameとRepositoryNameの代わりに使用し、
+	// orgへのインストール、ユーザーへのインストールの順に参照します。
+	AccountName string
+	// RepositoryLookupIdはインストールを参照するリポジトリのIDです。
+	// 0でない場合は名前の代わりにIDでリポジトリへのインストールを参照するため、リポジトリの名前が変わっても影響を受けません。
+	RepositoryLookupId int
+	// Permissionsはトークンに要求する権限です。空の場合はインストールの全権限になります。
+	Permissions map[string]string
+	// SingleFileはsingle_file権限でアクセスするファイルのパスです。
+	// 指定する場合はPermissionsにsingle_fileのreadまたはwriteを含める必要があります。
+	SingleFile string
+	// Repositoriesはトークンでアクセスできるリポジトリ名です。空の場合は制限しません。
+	Repositories []string
+	// RepositoryIdsはトークンでアクセスできるリポジトリのIDです。
+	// 名前と異なりリポジトリの名前を変更しても変わりません。Repositoriesと同時には指定できません。
+	RepositoryIds []int
+	// ConcurrencyはGetAllInstallationsで同時にトークンを取得する数です。
+	// 0の場合はDefaultConcurrencyを使用します。
+	Concurrency int
+	// MaxRetriesは5xxのレスポンスや通信エラーの場合に再送する最大回数です。
+	MaxRetries int
+	// RetryBaseDelayは再送の間隔の基準値です。既定値は1秒です。
+	RetryBaseDelay time.Duration
+	// RetryDnsがtrueの場合は一時的な名前解決の失敗も再送します。
+	// falseの場合は名前解決に失敗した時点でエラーを返します。
+	RetryDns bool
+	// RetryMaxDelayは再送の間隔の上限です。既定値はDefaultRetryMaxDelayです。
+	RetryMaxDelay time.Duration
+	// MaxRetryWaitはレート制限時にRetry-Afterに従って待つ最大時間です。既定値は1分です。
+	MaxRetryWait time.Duration
+	// TimeoutはHTTPリクエスト1回あたりのタイムアウトです。既定値は30秒です。
+	Timeout time.Duration
+	// Deadlineはインストールの参照から再送を含めてトークンを取得するまでの全体の制限時間です。
+	// Timeoutはリクエスト1回ごとに、Deadlineは操作全体に適用され、先に達した方で中断します。
+	// 0の場合は制限しません。
+	Deadline time.Duration
+	// ProxyはプロキシのURLです。空の場合は環境変数の設定に従います。
+	Proxy string
+	// CaCertPathはサーバー証明書の検証に使用するCA証明書バンドルのパスです。
+	// 空の場合はシステムの証明書を使用します。
+	CaCertPath string
+	// InsecureSkipVerifyがtrueの場合はサーバー証明書を検証しません。テスト用途に限ります。
+	InsecureSkipVerify bool
+	// HttpClientはGitHubへのリクエストに使用するクライアントです。
+	// テストでhttptest.Serverやスタブのhttp.RoundTripperを使用する場合に指定します。
+	// 指定した場合はTimeout、Proxy、CaCertPathとInsecureSkipVerifyは使用しません。
+	HttpClient *http.Client
+	// JwtLifetimeはJWTの有効期間です。MaxJwtLifetimeを超えることはできません。
+	// 既定値はDefaultJwtLifetimeです。
+	JwtLifetime time.Duration
+	// JwtClockSkewは時計のずれを考慮してJWTの発行時刻(iat)を遡らせる時間です。
+	// 既定値はDefaultJwtClockSkewです。
+	JwtClockSkew time.Duration
+	// AllowClockSkewRetryがtrueの場合、iatが未来の時刻であるとしてJWTが拒否されたときに
+	// iatをさらに遡らせて1回だけ再試行します。
+	AllowClockSkewRetry bool
+	// CacheFileはトークンを保存するキャッシュファイルのパスです。
+	// 空でない場合は有効期限までRefreshMargin以上あるキャッシュ済みのトークンを再利用します。
+	// 1つのファイルに取得条件ごとのトークンを保存し、書き込み時は"<CacheFile>.lock"でロックするため、
+	// 複数のプロセスで共有できます。
+	CacheFile string
+	// RefreshMarginはキャッシュやTokenSourceのトークンを取得し直す有効期限までの残り時間です。
+	// TokenLifetimeより短い必要があります。0の場合はDefaultRefreshWindowを使用します。
+	RefreshMargin time.Duration
+	// ValidateAppがtrueの場合はトークンを取得する前にGET /appを呼び出し、
+	// 秘密鍵がAppIdまたはClientIdのAppのものであることを確認します。
+	ValidateApp bool
+	// LogAppがtrueの場合はトークンを取得する前にGET /appを呼び出し、Appのslugと所有者をデバッグログに出力します。
+	// 呼び出しが1回増えるため、どのAppを使用しているか調べる場合に限ります。
+	LogApp bool
+	// PerPageは一覧を取得する際の1ページの件数です。MaxPerPageを超える場合はMaxPerPageにします。
+	// 0の場合はGitHub APIの既定値を使用します。
+	PerPage int
+	// AcceptはリクエストのAcceptヘッダの値です。プレビュー版のAPIを使用する場合に指定します。
+	// 空の場合はDefaultAcceptを使用します。
+	Accept string
+	// ApiVersionはリクエストのX-GitHub-Api-Versionヘッダの値です。空の場合はDefaultApiVersionを使用します。
+	ApiVersion string
+	// UserAgentはリクエストのUser-Agentヘッダの値です。空の場合はDefaultUserAgentを使用します。
+	UserAgent string
+	// HeadersはGitHubへのリクエストに追加するヘッダです。プロキシやゲートウェイが要求する場合に指定します。
+	// 認証や応答の形式が変わらないよう、IsReservedHeaderのヘッダは上書きせず無視します。
+	// 値は秘匿情報を含み得るため、ログには出力しません。
+	Headers http.Header
+	// MaxResponseBytesは成功したレスポンスボディを読み込む上限です。
+	// 0の場合はDefaultMaxResponseBytesを使用します。
+	MaxResponseBytes int64
+	// RateLimitFuncはレート制限のヘッダを含むレスポンスを受け取るたびに呼び出されます。
+	// nilの場合は呼び出しません。
+	RateLimitFunc func(method string, url string, rateLimit RateLimit)
+	// JwtFuncはJWTを署名するたびに署名したJWTとその有効期限を引数に呼び出されます。
+	// インストールの参照などに使用したJWTを再利用する場合に指定します。
+	// GetAllInstallationsでは複数のゴルーチンから呼び出されます。nilの場合は呼び出しません。
+	JwtFunc func(jwt string, expiresAt time.Time)
+	// Loggerは警告や通信の詳細を出力する先です。nilの場合は出力しません。
+	Logger Logger
+
+	// keysはLoadKeysで読み込んだ鍵です。nilの場合は使用するたびに読み込みます。
+	keys []*signingKey
+}
+
+// Tokenは取得したインストールアクセストークンです。
+// PermissionsとRepositorySelectionは実際に付与された内容で、Appに権限が無い場合などは要求と異なることがあります。
+type Token struct {
+	Token          string `json:"token"`
+	ExpiresAt      string `json:"expires_at"`
+	InstallationId int    `json:"installation_id"`
+	// Permissionsはトークンに付与された権限です。
+	Permissions map[string]string `json:"permissions,omitempty"`
+	// RepositorySelectionはトークンでアクセスできるリポジトリの範囲です。(allまたはselected)
+	RepositorySelection string `json:"repository_selection,omitempty"`
+	// RateLimitはトークンを取得したレスポンスのレート制限の状態です。
+	// ヘッダが無い場合やキャッシュから取得した場合はnilです。
+	RateLimit *RateLimit `json:"rate_limit,omitempty"`
+}
+
+// ExpiresAtTimeはExpiresAtを解析した有効期限の時刻を返します。
+func (token *Token) ExpiresAtTime() (time.Time, error) {
+	expiresAt, err := time.Parse(time.RFC3339, token.ExpiresAt)
+	if err != nil {
+		return time.Time{}, wrapError(KindNetwork, fmt.Errorf("malformed expires_at %q: %w", token.ExpiresAt, err))
+	}
+	return expiresAt, nil
+}
+
+// Fieldはログに付加する値です。
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Loggerは警告や通信の詳細を出力する先です。
+// Debugに渡すメッセージや値ではトークンやJWTは常に伏せられています。
+type Logger interface {
+	Warn(msg string, fields ...Field)
+	Debug(msg string, fields ...Field)
+}
+
+// warnはLoggerが設定されている場合に警告メッセージを出力します。
+func (args *AccessToken) warn(msg string, fields ...Field) {
+	if args.Logger != nil {
+		args.Logger.Warn(msg, fields...)
+	}
+}
+
+// debugはLoggerが設定されている場合に通信の詳細を出力します。
+func (args *AccessToken) debug(msg string, fields ...Field) {
+	if args.Logger != nil {
+		args.Logger.Debug(msg, fields...)
+	}
+}
+
+type installationApiResponse struct {
+	Id              int     `json:"id"`
+	AccessTokensUrl *string `json:"access_tokens_url"`
+}
+
+type accessTokenApiRequest struct {
+	Repositories  []string          `json:"repositories,omitempty"`
+	RepositoryIds []int             `json:"repository_ids,omitempty"`
+	Permissions   map[string]string `json:"permissions,omitempty"`
+	// SingleFileNameはsingle_file権限でアクセスするファイルのパスです。
+	SingleFileName string `json:"single_file_name,omitempty"`
+}
+
+type accessTokenApiResponse struct {
+	Token               string            `json:"token"`
+	ExpiresAt           string            `json:"expires_at"`
+	Permissions         map[string]string `json:"permissions"`
+	RepositorySelection string            `json:"repository_selection"`
+
+	// rateLimitはトークンを取得したレスポンスのレート制限の状態です。
+	rateLimit *RateLimit
+}
+
+// getApiUrlは末尾のスラッシュを除いたAPIのベースURLを返します。
+func (args *AccessToken) getApiUrl() (string, error) {
+	apiUrl := args.ApiUrl
+	if apiUrl == "" {
+		apiUrl = DefaultApiUrl
+	}
+
+	parsed, err := url.Parse(apiUrl)
+	if err != nil {
+		return "", wrapError(KindInvalidArgument, fmt.Errorf("invalid api url: %w", err))
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", wrapError(KindInvalidArgument, fmt.Errorf("invalid api url: %s", apiUrl))
+	}
+
+	return strings.TrimRight(apiUrl, "/"), nil
+}
+
+// installationLookupはインストール情報を参照するAPIのパスと、参照するオーナーです。
+type installationLookup struct {
+	path  string
+	owner string
+}
+
+// getInstallationLookupsはインストール情報を取得するAPIのパスを参照する順に返します。
+// リポジトリのIDが指定されている場合はIDでリポジトリへのインストールを参照します。
+// accountが指定されている場合はorgへのインストール、ユーザーへのインストールの順に参照します。
+// それ以外はrepoが指定されている場合はリポジトリへのインストールを、
+// 省略されている場合はorgへのインストールを参照し、FallbackOrganizationNameが指定されている場合は
+// 続けてそのオーナーで同じく参照します。
+func (args *AccessToken) getInstallationLookups() []installationLookup {
+	if args.RepositoryLookupId != 0 {
+		return []installationLookup{{path: fmt.Sprintf("/repositories/%d/installation", args.RepositoryLookupId)}}
+	}
+	if args.AccountName != "" {
+		return []installationLookup{
+			{path: fmt.Sprintf("/orgs/%s/installation", args.AccountName), owner: args.AccountName},
+			{path: fmt.Sprintf("/users/%s/installation", args.AccountName), owner: args.AccountName},
+		}
+	}
+
+	owners := []string{args.OrganizationName}
+	if args.FallbackOrganizationName != "" {
+		owners = append(owners, args.FallbackOrganizationName)
+	}
+
+	lookups := []installationLookup{}
+	for _, owner := range owners {
+		if args.RepositoryName == "" {
+			lookups = append(lookups, installationLookup{path: fmt.Sprintf("/orgs/%s/installation", owner), owner: owner})
+		} else {
+			lookups = append(lookups, installationLookup{path: fmt.Sprintf("/repos/%s/%s/installation", owner, args.RepositoryName), owner: owner})
+		}
+	}
+	return lookups
+}
+
+// tokenUrlPlaceholderはTokenUrlでインストールIDに置き換える文字列です。
+const tokenUrlPlaceholder = "{installation_id}"
+
+// validateTokenUrlはTokenUrlがインストールIDを含むテンプレートであることを確認します。
+func (args *AccessToken) validateTokenUrl() error {
+	if args.TokenUrl != "" && !strings.Contains(args.TokenUrl, tokenUrlPlaceholder) {
+		return wrapError(KindInvalidArgument, fmt.Errorf("token url must contain %s: %s", tokenUrlPlaceholder, args.TokenUrl))
+	}
+	return nil
+}
+
+// getAccessTokensUrlはインストールアクセストークンを取得するURLを返します。
+// TokenUrlが指定されている場合はインストール情報のaccessTokensUrlの代わりにTokenUrlから組み立てます。
+func (args *AccessToken) getAccessTokensUrl(installationId int, accessTokensUrl *string) (*string, error) {
+	if args.TokenUrl == "" {
+		return accessTokensUrl, nil
+	}
+
+	if installationId == 0 {
+		return nil, wrapError(KindNetwork, fmt.Errorf("installation id is not available to build the token url"))
+	}
+
+	tokenUrl := strings.ReplaceAll(args.TokenUrl, tokenUrlPlaceholder, strconv.Itoa(installationId))
+	if strings.HasPrefix(tokenUrl, "/") {
+		apiUrl, err := args.getApiUrl()
+		if err != nil {
+			return nil, err
+		}
+		tokenUrl = apiUrl + tokenUrl
+	}
+
+	args.debug("using token url instead of access_tokens_url", Field{"url", tokenUrl})
+	return &tokenUrl, nil
+}
+
+// getIssuerはJWTのissに設定するAppの識別子を返します。
+// JwtIssuerが指定されている場合はそれを使用し、
+// それ以外はGitHubの推奨に従いClient IDが指定されている場合はそちらを優先します。
+func (args *AccessToken) getIssuer() (string, error) {
+	if args.JwtIssuer != "" {
+		return args.JwtIssuer, nil
+	}
+	if args.ClientId != "" {
+		return args.ClientId, nil
+	}
+	if args.AppId != "" {
+		return args.AppId, nil
+	}
+	return "", wrapError(KindInvalidArgument, fmt.Errorf("app id, client id or jwt issuer is not set"))
+}
+
+// getJwtPeriodはJWTの有効期間と発行時刻を遡らせる時間を返します。
+func (args *AccessToken) getJwtPeriod() (time.Duration, time.Duration, error) {
+	lifetime := DefaultJwtLifetime
+	if args.JwtLifetime > 0 {
+		lifetime = args.JwtLifetime
+	}
+	if lifetime > MaxJwtLifetime {
+		return 0, 0, wrapError(KindInvalidArgument, fmt.Errorf("jwt lifetime %s exceeds the maximum of %s allowed by GitHub", lifetime, MaxJwtLifetime))
+	}
+
+	skew := DefaultJwtClockSkew
+	if args.JwtClockSkew > 0 {
+		skew = args.JwtClockSkew
+	}
+
+	return lifetime, skew, nil
+}
+
+// getAuthorizationはkeyで署名したJWTをAuthorizationヘッダに設定する資格情報として返します。
+func (args *AccessToken) getAuthorization(key *signingKey) (*jwtAuthorization, error) {
+	return args.getAuthorizationWithBackdate(key, 0)
+}
+
+// getAuthorizationWithBackdateはJWTの発行時刻をJwtClockSkewよりさらにbackdateだけ遡らせて
+// Authorizationヘッダに設定する資格情報を返します。署名し直す場合も同じだけ遡らせます。
+func (args *AccessToken) getAuthorizationWithBackdate(key *signingKey, backdate time.Duration) (*jwtAuthorization, error) {
+	authorization := &jwtAuthorization{args: args, key: key, backdate: backdate}
+	err := authorization.sign()
+	if err != nil {
+		return nil, err
+	}
+	return authorization, nil
+}
+
+// signJwtはkeyで署名したJWTとその有効期間、有効期限を返します。
+func (args *AccessToken) signJwt(key *signingKey, backdate time.Duration) (string, time.Duration, time.Time, error) {
+	issuer, err := args.getIssuer()
+	if err != nil {
+		return "", 0, time.Time{}, err
+	}
+
+	lifetime, skew, err := args.getJwtPeriod()
+	if err != nil {
+		return "", 0, time.Time{}, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(lifetime)
+	token := jwt.NewWithClaims(
+		key.method,
+		jwt.MapClaims{
+			"iss": issuer,
+			"iat": jwt.NewNumericDate(now.Add(-skew - backdate)),
+			"exp": jwt.NewNumericDate(expiresAt),
+		},
+	)
+
+	ss, err := token.SignedString(key.key)
+	if err != nil {
+		return "", 0, time.Time{}, wrapError(KindAuth, fmt.Errorf("failed to sign jwt: %w", err))
+	}
+
+	if args.JwtFunc != nil {
+		args.JwtFunc(ss, expiresAt)
+	}
+
+	return ss, lifetime, expiresAt, nil
+}
+
+// getInstallationはgithubからインストール情報を取得して返します。
+// アクセストークンを取得するためのエンドポイントはAccessTokensUrlに含まれます。
+func (args *AccessToken) getInstallation(ctx context.Context, client *http.Client, authorization authorizer) (*installationApiResponse, error) {
+	// get installation api
+	apiUrl, err := args.getApiUrl()
+	if err != nil {
+		return nil, err
+	}
+
+	// インストールIDが分かっている場合はエンドポイントを組み立てるだけで通信しない
+	if args.InstallationId != 0 {
+		accessTokensUrl := fmt.Sprintf("%s/app/installations/%d/access_tokens", apiUrl, args.InstallationId)
+		return &installationApiResponse{
+			Id:              args.InstallationId,
+			AccessTokensUrl: &accessTokensUrl,
+		}, nil
+	}
+
+	// GHEの場合もaccess_tokens_urlは完全なURLで返されるためそのまま使用する
+	lookups := args.getInstallationLookups()
+	for i, lookup := range lookups {
+		installationApiResponse := installationApiResponse{}
+		installationApiUrl := apiUrl + lookup.path
+		err = args.send(ctx, client, authorization, "GET", &installationApiUrl, nil, &installationApiResponse)
+
+		// repoを省略した場合はAppのインストールの一覧から参照中のオーナーのインストールを探す
+		if isNotFound(err) && args.RepositoryName == "" && args.AccountName == "" && args.RepositoryLookupId == 0 {
+			installation, findErr := args.findOwnerInstallation(ctx, client, authorization, apiUrl, lookup.owner)
+			if findErr != nil {
+				return nil, args.addAuthHint(findErr)
+			}
+			if installation != nil {
+				args.warnFallbackOrganization(i)
+				return installation, nil
+			}
+		}
+		// accountがorgでない場合は404になるため、ユーザーへのインストールを参照する
+		if isNotFound(err) && i < len(lookups)-1 {
+			args.debug("installation not found, trying the next lookup", Field{"url", installationApiUrl})
+			continue
+		}
+		if isNotFound(err) && args.AccountName != "" {
+			paths := []string{}
+			for _, lookup := range lookups {
+				paths = append(paths, lookup.path)
+			}
+			return nil, fmt.Errorf("app is not installed on account %s (looked up %s): %w", args.AccountName, strings.Join(paths, " and "), err)
+		}
+		if err != nil {
+			return nil, args.addAuthHint(err)
+		}
+
+		args.warnFallbackOrganization(i)
+		return &installationApiResponse, nil
+	}
+
+	return nil, fmt.Errorf("no installation lookup for the arguments")
+}
+
+// warnFallbackOrganizationはi番目の参照が予備のオーナーのものである場合に警告します。
+// 移管元で見つからなかったことが分かるようにするためです。
+// accountを指定した場合の2番目の参照はユーザーへのインストールで、予備のオーナーではありません。
+func (args *AccessToken) warnFallbackOrganization(i int) {
+	if i > 0 && args.AccountName == "" && args.FallbackOrganizationName != "" {
+		args.warn(fmt.Sprintf("installation was not found for %s, using fallback org %s", args.OrganizationName, args.FallbackOrganizationName))
+	}
+}
+
+// addAuthHintはJWTが拒否された(401)場合に確認すべき点をerrに付け加えます。
+// 401は時計のずれでJWTの有効期間外となった場合や、別のAppの鍵で署名した場合に返されます。
+func (args *AccessToken) addAuthHint(err error) error {
+	if !isUnauthorized(err) {
+		return err
+	}
+
+	issuer, _ := args.getIssuer()
+	return fmt.Errorf("%w: check that the system clock is correct and the private key belongs to app %s", err, issuer)
+}
+
+// getAccessTokenRequestはアクセストークンの取得時に送信するリクエストボディを返します。
+// 権限もリポジトリも指定されていない場合はnilを返します。
+func (args *AccessToken) getAccessTokenRequest() (*accessTokenApiRequest, error) {
+	err := validatePermissions(args.Permissions)
+	if err != nil {
+		return nil, wrapError(KindInvalidArgument, err)
+	}
+
+	err = validateSingleFile(args.Permissions, args.SingleFile)
+	if err != nil {
+		return nil, wrapError(KindInvalidArgument, err)
+	}
+
+	if len(args.Repositories) > 0 && len(args.RepositoryIds) > 0 {
+		return nil, wrapError(KindInvalidArgument, fmt.Errorf("repositories and repository ids cannot be used together"))
+	}
+
+	if len(args.Permissions) == 0 && len(args.Repositories) == 0 && len(args.RepositoryIds) == 0 {
+		return nil, nil
+	}
+
+	return &accessTokenApiRequest{
+		Repositories:   args.Repositories,
+		RepositoryIds:  args.RepositoryIds,
+		Permissions:    args.Permissions,
+		SingleFileName: args.SingleFile,
+	}, nil
+}
+
+// getAccessTokenはauthorizationのJWTで認証してgithubからアクセストークンを取得して返します。
+func (args *AccessToken) getAccessToken(ctx context.Context, client *http.Client, authorization authorizer, endpoint *string, request *accessTokenApiRequest) (*accessTokenApiResponse, error) {
+	// リクエストボディが無い場合は従来通りボディなしで送信する
+	var body interface{}
+	if request != nil {
+		body = request
+	}
+
+	accessTokenApiResponse := accessTokenApiResponse{}
+	meta, err := args.sendWithMeta(ctx, client, authorization, "POST", endpoint, body, &accessTokenApiResponse)
+	if err != nil {
+		return nil, args.addAuthHint(err)
+	}
+	accessTokenApiResponse.rateLimit = meta.RateLimit
+
+	return &accessTokenApiResponse, nil
+}
+
+// Getはアクセストークンを取得して有効期限とともに返します。
+func (args *AccessToken) Get() (*Token, error) {
+	return args.GetContext(context.Background())
+}
+
+// GetContextはctxを使用してアクセストークンを取得して有効期限とともに返します。
+// ctxがキャンセルされた場合は通信を中断します。
+// Deadlineが指定されている場合は全体の処理がその時間内に終わらなければ中断します。
+func (args *AccessToken) GetContext(ctx context.Context) (*Token, error) {
+	return args.getContext(ctx, nil)
+}
+
+// getContextはGetContextと同様にアクセストークンを取得します。
+// clientがnilでない場合は新しいクライアントを作成せず、呼び出し側の後続の通信と共有します。
+func (args *AccessToken) getContext(ctx context.Context, client *http.Client) (*Token, error) {
+	if args.Deadline <= 0 {
+		return args.getToken(ctx, client)
+	}
+
+	deadlineCtx, cancel := context.WithTimeout(ctx, args.Deadline)
+	defer cancel()
+
+	token, err := args.getToken(deadlineCtx, client)
+
+	// 呼び出し側のctxではなくDeadlineによって中断された場合はその旨を示す
+	if err != nil && deadlineCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return nil, wrapError(KindNetwork, fmt.Errorf("operation did not complete within deadline %s: %w", args.Deadline, err))
+	}
+
+	return token, err
+}
+
+// getTokenはctxを使用してアクセストークンを取得します。
+// clientがnilの場合はキャッシュに無いときにのみ作成します。
+func (args *AccessToken) getToken(ctx context.Context, client *http.Client) (*Token, error) {
+	// 通信する前に引数の誤りを検出する
+	request, err := args.getAccessTokenRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	_, _, err = args.getJwtPeriod()
+	if err != nil {
+		return nil, err
+	}
+
+	err = args.validateRefreshMargin()
+	if err != nil {
+		return nil, err
+	}
+
+	err = args.validateTokenUrl()
+	if err != nil {
+		return nil, err
+	}
+
+	cacheKey := ""
+	if args.CacheFile != "" {
+		cacheKey, err = args.getCacheKey()
+		if err != nil {
+			return nil, err
+		}
+
+		if cached := args.readCache(cacheKey); cached != nil {
+			return cached, nil
+		}
+	}
+
+	keys, err := args.getSigningKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	// インストールの参照とトークンの取得で同じクライアントを使用し、接続と設定を共有する
+	if client == nil {
+		client, err = args.newHttpClient()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var token *Token
+	for i, key := range keys {
+		token, err = args.getTokenWithKey(ctx, client, key, request)
+
+		// 鍵の更新中は古い鍵が拒否されるため、401の場合のみ次の鍵を試す
+		if isUnauthorized(err) && i < len(keys)-1 {
+			args.debug("private key was rejected, trying the next key", Field{"key_index", i})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(keys) > 1 {
+			args.debug("authenticated with private key", Field{"key_index", i})
+		}
+		break
+	}
+
+	// Appやインストールに権限が無い場合は要求した権限が黙って下げられる
+	if downgraded := findDowngradedPermissions(args.Permissions, token.Permissions); len(downgraded) > 0 && !args.SkipPermissionsCheck {
+		if args.StrictPermissions {
+			return nil, wrapError(KindAuth, fmt.Errorf("requested permissions were not granted: %s", strings.Join(downgraded, ", ")))
+		}
+		for _, message := range downgraded {
+			args.warn("requested permission " + message)
+		}
+	}
+
+	// キャッシュに保存できなくてもトークンは取得できているので警告に留める
+	if args.CacheFile != "" {
+		err = args.writeCache(cacheKey, token)
+		if err != nil {
+			args.warn("failed to write cache", Field{"error", err.Error()})
+		}
+	}
+
+	return token, nil
+}
+
+// getTokenWithKeyはkeyで署名したJWTで認証してアクセストークンを取得します。
+// 一連の呼び出しでは同じJWTを使用し、再送の待ち時間などで有効期限が近づいた場合のみ署名し直します。
+// AllowClockSkewRetryが指定されている場合、時計が進んでいるためにJWTが拒否されたときは
+// 発行時刻をさらに遡らせて署名し直し、1回だけ再試行します。
+func (args *AccessToken) getTokenWithKey(ctx context.Context, client *http.Client, key *signingKey, request *accessTokenApiRequest) (*Token, error) {
+	authorization, err := args.getAuthorization(key)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := args.getTokenWithAuthorization(ctx, client, authorization, request)
+	if !args.AllowClockSkewRetry || !isClockSkewError(err) {
+		return token, err
+	}
+
+	args.debug("jwt was rejected because iat is in the future, retrying with backdated iat", Field{"backdate", clockSkewRetryBackdate.String()})
+
+	authorization, err = args.getAuthorizationWithBackdate(key, clockSkewRetryBackdate)
+	if err != nil {
+		return nil, err
+	}
+
+	return args.getTokenWithAuthorization(ctx, client, authorization, request)
+}
+
+// getTokenWithAuthorizationはauthorizationのJWTで認証してアクセストークンを取得します。
+func (args *AccessToken) getTokenWithAuthorization(ctx context.Context, client *http.Client, authorization authorizer, request *accessTokenApiRequest) (*Token, error) {
+	switch {
+	case args.ValidateApp:
+		app, err := args.validateApp(ctx, client, authorization)
+		if err != nil {
+			return nil, err
+		}
+		args.logApp(app)
+	case args.LogApp:
+		// 診断のための呼び出しなので、失敗してもトークンの取得は続ける
+		app, err := args.getApp(ctx, client, authorization)
+		if err != nil {
+			args.debug("failed to get app", Field{"error", err.Error()})
+		} else {
+			args.logApp(app)
+		}
+	}
+
+	installation, err := args.getInstallation(ctx, client, authorization)
+	if err != nil {
+		return nil, err
+	}
+
+	accessTokensUrl, err := args.getAccessTokensUrl(installation.Id, installation.AccessTokensUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := args.getAccessToken(ctx, client, authorization, accessTokensUrl, request)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Token{
+		Token:               response.Token,
+		ExpiresAt:           response.ExpiresAt,
+		InstallationId:      installation.Id,
+		Permissions:         response.Permissions,
+		RepositorySelection: response.RepositorySelection,
+		RateLimit:           response.rateLimit,
+	}, nil
+}
+
+// SignJwtはGitHub Appとして認証するためのJWTを署名して返します。
+// インストールの参照やトークンの取得は行いません。
+func (args *AccessToken) SignJwt() (string, error) {
+	signed, _, err := args.SignJwtWithExpiry()
+	return signed, err
+}
+
+// SignJwtWithExpiryはSignJwtと同じくJWTを署名し、その有効期限とともに返します。
+func (args *AccessToken) SignJwtWithExpiry() (string, time.Time, error) {
+	key, err := args.getSigningKey()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	authorization, err := args.getAuthorization(key)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return authorization.value, authorization.expiresAt, nil
+}
+
+// Revokeはインストールアクセストークンを失効させます。
+func (args *AccessToken) Revoke(token string) error {
+	return args.RevokeContext(context.Background(), token)
+}
+
+// RevokeContextはctxを使用してインストールアクセストークンを失効させます。
+// 失効させるトークン自身を認証に使用するため、秘密鍵は不要です。
+func (args *AccessToken) RevokeContext(ctx context.Context, token string) error {
+	apiUrl, err := args.getApiUrl()
+	if err != nil {
+		return err
+	}
+
+	client, err := args.newHttpClient()
+	if err != nil {
+		return err
+	}
+
+	revokeApiUrl := apiUrl + "/installation/token"
+	return args.send(ctx, client, bearerToken(token), "DELETE", &revokeApiUrl, nil, nil)
+}
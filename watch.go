@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/zerosspec-dev/github-app-token/token"
+)
+
+// watchRetryDelayは-watchでトークンの取得に失敗した場合に再試行するまでの時間です。
+const watchRetryDelay = time.Minute
+
+// getWatchDelayはトークンを取得し直すまでの時間を返します。
+// 有効期限の-refresh-margin前で、既に過ぎている場合は0です。
+func getWatchDelay(args *token.AccessToken, result *token.Token) time.Duration {
+	margin := args.RefreshMargin
+	if margin <= 0 {
+		margin = token.DefaultRefreshWindow
+	}
+
+	expiresAt, err := result.ExpiresAtTime()
+	if err != nil {
+		return 0
+	}
+
+	delay := time.Until(expiresAt) - margin
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// runWatchはトークンを取得して-output-fileに書き出し、有効期限が近づくたびに書き換えます。
+// SIGINTまたはSIGTERMを受け取るまで終了しません。
+// 最初の取得に失敗した場合は設定の誤りとして終了し、以降の失敗は警告してwatchRetryDelay後に再試行します。
+// -pem -のように1回しか読めない鍵でも取得し直せるよう、秘密鍵は開始時に1回だけ読み込みます。
+func runWatch(args *token.AccessToken, opts *options) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := args.LoadKeys()
+	if err != nil {
+		exitWithError(addHint(err))
+	}
+
+	first := true
+	for {
+		delay := watchRetryDelay
+
+		result, err := args.GetContext(ctx)
+		if err == nil {
+			logger.addSecret(result.Token)
+			err = verifyLeastPrivilege(args, opts, result)
+		}
+		if err == nil {
+			err = writeOutputFile(args, opts, result)
+		}
+
+		switch {
+		case ctx.Err() != nil:
+		case err != nil && first:
+			exitWithError(addHint(err))
+		case err != nil:
+			logger.Warn("failed to refresh the token, retrying", token.Field{Key: "error", Value: err.Error()}, token.Field{Key: "delay", Value: delay.String()})
+		default:
+			delay = getWatchDelay(args, result)
+			logger.Info("token refreshed", token.Field{Key: "expires_at", Value: result.ExpiresAt}, token.Field{Key: "next_refresh", Value: time.Now().Add(delay).UTC().Format(time.RFC3339)})
+		}
+		first = false
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logger.Info("stopping watch")
+			return
+		case <-timer.C:
+		}
+	}
+}